@@ -36,6 +36,15 @@ func TestAcceptancePythonFn(t *testing.T) {
 			MustUse:    []string{pythonPIP, pythonFF},
 			MustNotUse: []string{entrypoint},
 		},
+		{
+			// Served by the gunicorn of the converter requirements, which must load the app factory.
+			Name:       "function without framework",
+			App:        "without_framework",
+			Path:       "/testFunction",
+			Env:        []string{"FUNC_NAME=testFunction"},
+			MustUse:    []string{pythonPIP, pythonFF},
+			MustNotUse: []string{entrypoint},
+		},
 	}
 	for _, tc := range testCases {
 		tc := tc
@@ -0,0 +1,234 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/buildpacks/pkg/env"
+	gcp "github.com/GoogleCloudPlatform/buildpacks/pkg/gcpbuildpack"
+	"github.com/buildpacks/libcnb"
+)
+
+const (
+	defaultMavenRepository         = "https://repo.maven.apache.org/maven2"
+	defaultMavenSnapshotRepository = "https://s01.oss.sonatype.org/content/repositories/snapshots"
+	defaultRepositoryID            = "functions-framework"
+	defaultFrameworkGroup          = "dev.openfunction.functions"
+	defaultFrameworkArtifactID     = "functions-framework-invoker"
+	defaultFrameworkVersion        = "1.2.0"
+	frameworkClassifier            = "jar-with-dependencies"
+
+	// dependencyPlugin copies a single artifact from the repositories without a project.
+	dependencyPlugin = "org.apache.maven.plugins:maven-dependency-plugin:3.3.0"
+	coordinatesKey   = "coordinates"
+
+	// mavenBindingType is the type of the service bindings that provide a Maven settings.xml, e.g. with the
+	// credentials of the repository of the functions framework.
+	mavenBindingType   = "maven"
+	bindingSettingsKey = "settings.xml"
+)
+
+// coordinates identify a Maven artifact.
+type coordinates struct {
+	group, artifact, version, classifier string
+}
+
+// String returns the coordinates in the form that `dependency:copy` accepts,
+// groupId:artifactId:version:packaging:classifier.
+func (c coordinates) String() string {
+	return fmt.Sprintf("%s:%s:%s:jar:%s", c.group, c.artifact, c.version, c.classifier)
+}
+
+func (c coordinates) snapshot() bool {
+	return strings.HasSuffix(c.version, "-SNAPSHOT")
+}
+
+// repository is a remote Maven repository. The id matches the server in settings.xml that holds its credentials.
+type repository struct {
+	id, url string
+}
+
+// String returns the repository in the form that `dependency:copy` accepts, id::layout::url.
+func (r repository) String() string {
+	return fmt.Sprintf("%s::default::%s", r.id, r.url)
+}
+
+// installFunctionsFramework installs the invoker jar of the functions framework into the layer. The jar is either
+// the one that FUNCTION_FRAMEWORK points to, a local path or a URL, or the artifact that Maven resolves from its
+// coordinates. The resolved jar is cached in the layer, keyed by its coordinates and repository; snapshots are
+// resolved on each build.
+func installFunctionsFramework(ctx *gcp.Context, layer *libcnb.Layer) error {
+	ffName := filepath.Join(layer.Path, "functions-framework.jar")
+	if jarPath, ok := os.LookupEnv(env.FunctionFrameworkJar); ok {
+		ctx.ClearLayer(layer)
+		// ClearLayer keeps the metadata, which must not match the coordinates once the jar is replaced.
+		delete(layer.Metadata, coordinatesKey)
+		if strings.HasPrefix(jarPath, "http://") || strings.HasPrefix(jarPath, "https://") {
+			return downloadFramework(ctx, ffName, jarPath)
+		}
+		if _, err := ctx.ExecWithErr([]string{"cp", jarPath, ffName}); err != nil {
+			return gcp.UserErrorf("copying functions framework jar %s: %v", jarPath, err)
+		}
+		return nil
+	}
+
+	c := frameworkCoordinates()
+	repo, err := frameworkRepository(c)
+	if err != nil {
+		return err
+	}
+	key := c.String() + "@" + repo.url
+	if !c.snapshot() && ctx.GetMetadata(layer, coordinatesKey) == key && ctx.FileExists(ffName) {
+		ctx.CacheHit(layerName)
+		ctx.Logf("Using cached functions framework %s.", c)
+		return nil
+	}
+	ctx.CacheMiss(layerName)
+	ctx.ClearLayer(layer)
+
+	if err := resolveFramework(ctx, c, repo, ffName); err != nil {
+		return err
+	}
+	ctx.SetMetadata(layer, coordinatesKey, key)
+	return nil
+}
+
+// frameworkCoordinates returns the coordinates of the invoker jar of the functions framework.
+func frameworkCoordinates() coordinates {
+	c := coordinates{
+		group:      os.Getenv(env.FunctionFrameworkGroup),
+		artifact:   os.Getenv(env.FunctionFrameworkArtifactID),
+		version:    os.Getenv(env.FunctionFrameworkVersion),
+		classifier: frameworkClassifier,
+	}
+	if c.group == "" {
+		c.group = defaultFrameworkGroup
+	}
+	if c.artifact == "" {
+		c.artifact = defaultFrameworkArtifactID
+	}
+	if c.version == "" {
+		c.version = defaultFrameworkVersion
+	}
+	return c
+}
+
+// frameworkRepository returns the repository of the functions framework. MAVEN_REPOSITORY is either a URL or
+// id::url, where id is the server in settings.xml that holds the credentials of the repository.
+func frameworkRepository(c coordinates) (repository, error) {
+	r := repository{id: defaultRepositoryID, url: os.Getenv(env.MavenRepository)}
+	if id, url, found := cut(r.url, "::"); found {
+		r.id, r.url = id, url
+	}
+	if r.url == "" {
+		r.url = defaultMavenRepository
+		if c.snapshot() {
+			r.url = defaultMavenSnapshotRepository
+		}
+	}
+	if !strings.HasPrefix(r.url, "http://") && !strings.HasPrefix(r.url, "https://") && !strings.HasPrefix(r.url, "file://") {
+		return repository{}, gcp.UserErrorf("%s must be a URL or id::url, got %q", env.MavenRepository, os.Getenv(env.MavenRepository))
+	}
+	r.url = strings.TrimSuffix(r.url, "/")
+	return r, nil
+}
+
+// resolveFramework resolves the invoker jar with Maven, which verifies its checksums, authenticates with the
+// credentials of settings.xml and, in offline mode, resolves it from the local repository, i.e. the m2 layer when
+// the function is built with Maven.
+func resolveFramework(ctx *gcp.Context, c coordinates, repo repository, ffName string) error {
+	offline, err := env.IsMavenOffline()
+	if err != nil {
+		return gcp.UserErrorf("failed to parse %s: %v", env.MavenOffline, err)
+	}
+	settings, err := mavenSettings(ctx.Bindings())
+	if err != nil {
+		return err
+	}
+
+	// Maven runs in an empty directory so that it does not load the function's pom.xml.
+	dir := ctx.TempDir("", "functions-framework-")
+	defer ctx.RemoveAll(dir)
+	if settings != "" {
+		ctx.WriteFile(filepath.Join(dir, bindingSettingsKey), []byte(settings), 0600)
+		ctx.Logf("Using Maven settings from %q service bindings.", mavenBindingType)
+	}
+	// The layer was cleared, so the copied jar is the only one in it.
+	output := filepath.Dir(ffName)
+
+	ctx.Logf("Resolving functions framework %s from %s.", c, repo.url)
+	if _, err := ctx.ExecWithErr(copyCommand(c, repo, output, settings != "", offline), gcp.WithWorkDir(dir), gcp.WithUserAttribution); err != nil {
+		if offline {
+			return gcp.UserErrorf("resolving functions framework %s offline, it is not in the local Maven repository: %v", c, err)
+		}
+		return gcp.UserErrorf("resolving functions framework %s from %s: %v", c, repo.url, err)
+	}
+	jars := ctx.Glob(filepath.Join(output, "*-"+c.classifier+".jar"))
+	if len(jars) != 1 {
+		return gcp.InternalErrorf("expected one functions framework jar in %s, found %d", output, len(jars))
+	}
+	ctx.Rename(jars[0], ffName)
+	return nil
+}
+
+// copyCommand returns the Maven command that copies the artifact into the output directory.
+func copyCommand(c coordinates, repo repository, output string, settings, offline bool) []string {
+	command := []string{"mvn", "--batch-mode", "--quiet", "--strict-checksums"}
+	if settings {
+		command = append(command, "--settings", bindingSettingsKey)
+	}
+	if offline {
+		command = append(command, "--offline")
+	}
+	return append(command,
+		dependencyPlugin+":copy",
+		"-Dartifact="+c.String(),
+		"-DremoteRepositories="+repo.String(),
+		"-DoutputDirectory="+output,
+	)
+}
+
+// mavenSettings returns the settings.xml of the maven bindings, or an empty string if there are none.
+func mavenSettings(bindings libcnb.Bindings) (string, error) {
+	var settings string
+	for _, b := range bindings {
+		if b.Type != mavenBindingType {
+			continue
+		}
+		s, ok := b.Secret[bindingSettingsKey]
+		if !ok {
+			return "", gcp.UserErrorf("%s binding %q must provide %q", mavenBindingType, b.Name, bindingSettingsKey)
+		}
+		if settings != "" {
+			return "", gcp.UserErrorf("found more than one %s binding, only one settings.xml can be used", mavenBindingType)
+		}
+		settings = s
+	}
+	return settings, nil
+}
+
+func downloadFramework(ctx *gcp.Context, name, url string) error {
+	if _, err := ctx.ExecWithErr([]string{"curl", "--silent", "--fail", "--show-error", "--location", "--output", name, url}); err != nil {
+		return gcp.InternalErrorf("fetching functions framework jar[%s]: %s", url, err.Error())
+	}
+
+	ctx.Logf("fetching functions framework jar from %s", url)
+
+	return nil
+}
@@ -0,0 +1,123 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"archive/zip"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/GoogleCloudPlatform/buildpacks/pkg/env"
+	gcp "github.com/GoogleCloudPlatform/buildpacks/pkg/gcpbuildpack"
+	"github.com/GoogleCloudPlatform/buildpacks/pkg/java"
+	"github.com/buildpacks/libcnb"
+)
+
+const (
+	// appClassCountEnv is the number of classes of the function, which launch.sh uses to size the metaspace.
+	appClassCountEnv = "JAVA_APP_CLASS_COUNT"
+	// archiveName is the class data sharing archive that launch.sh maps, next to it in the layer.
+	archiveName = "functions-framework.jsa"
+)
+
+// imageTime is the modification time of the files of images exported by the lifecycle. The JVM ignores a
+// class data sharing archive if the modification time of a jar on the classpath changed since it was created.
+var imageTime = time.Date(1980, time.January, 1, 0, 0, 1, 0, time.UTC)
+
+// configureJVM sets the number of classes that launch.sh uses to size the JVM, and creates a class data sharing
+// archive of the functions framework if FUNC_JAVA_CDS is set.
+func configureJVM(ctx *gcp.Context, layer *libcnb.Layer, frameworkJar, classpath string) error {
+	jars := []string{frameworkJar}
+	for _, entry := range strings.Split(classpath, ":") {
+		if strings.HasSuffix(entry, "/*") {
+			jars = append(jars, ctx.Glob(filepath.Join(strings.TrimSuffix(entry, "*"), "*.jar"))...)
+		} else {
+			jars = append(jars, entry)
+		}
+	}
+	classes := 0
+	for _, jar := range jars {
+		names, err := classNames(jar)
+		if err != nil {
+			ctx.Warnf("Failed to count the classes of %s, skipping: %v.", jar, err)
+			continue
+		}
+		classes += len(names)
+	}
+	layer.LaunchEnvironment.Default(appClassCountEnv, strconv.Itoa(classes))
+
+	// The layer may be cached from a previous build.
+	archive := filepath.Join(layer.Path, archiveName)
+	ctx.RemoveAll(archive)
+	cds, err := env.IsJavaCDS()
+	if err != nil {
+		return gcp.UserErrorf("failed to parse %s: %v", env.JavaCDS, err)
+	}
+	if cds {
+		createArchive(ctx, frameworkJar, archive)
+	}
+	return nil
+}
+
+// createArchive dumps the classes of the functions framework into a class data sharing archive with the Java
+// runtime that launches the function, as the JVM ignores archives created by other builds of the JVM. Failing to
+// create it, e.g. on Java 8, only warns as the function runs without it.
+func createArchive(ctx *gcp.Context, frameworkJar, archive string) {
+	names, err := classNames(frameworkJar)
+	if err != nil {
+		ctx.Warnf("Skipping the class data sharing archive, reading %s failed: %v", frameworkJar, err)
+		return
+	}
+	dir := ctx.TempDir("", "cds-")
+	defer ctx.RemoveAll(dir)
+	classList := filepath.Join(dir, "classes.lst")
+	ctx.WriteFile(classList, []byte(strings.Join(names, "\n")+"\n"), 0644)
+	if err := os.Chtimes(frameworkJar, imageTime, imageTime); err != nil {
+		ctx.Warnf("Skipping the class data sharing archive, setting the time of %s failed: %v", frameworkJar, err)
+		return
+	}
+
+	javaBin := "java"
+	if home := os.Getenv(java.LaunchHomeEnv); home != "" {
+		javaBin = filepath.Join(home, "bin", "java")
+	}
+	ctx.Logf("Creating a class data sharing archive of %d classes.", len(names))
+	command := []string{javaBin, "-Xshare:dump", "-XX:SharedClassListFile=" + classList, "-XX:SharedArchiveFile=" + archive, "-cp", frameworkJar}
+	if _, err := ctx.ExecWithErr(command, gcp.WithUserAttribution); err != nil {
+		ctx.Warnf("Skipping the class data sharing archive, creating it failed: %v", err)
+		ctx.RemoveAll(archive)
+	}
+}
+
+// classNames returns the names of the classes in a jar, in the form of a class list, e.g. `java/lang/Object`.
+func classNames(jar string) ([]string, error) {
+	r, err := zip.OpenReader(jar)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	var names []string
+	for _, f := range r.File {
+		name := f.Name
+		if !strings.HasSuffix(name, ".class") || strings.HasPrefix(name, "META-INF/") || strings.HasSuffix(name, "module-info.class") {
+			continue
+		}
+		names = append(names, strings.TrimSuffix(name, ".class"))
+	}
+	return names, nil
+}
@@ -13,14 +13,99 @@
 # See the License for the specific language governing permissions and
 # limitations under the License.
 
-# Java launcher that configures memory based on how much is available.
-# If the environment variable $JAVA_TOOL_OPTIONS is set (even if empty), we just
-# launch the java command we are given. If it is not set, and we have a hint
-# from the environment of what the memory size is, then we set JAVA_TOOL_OPTIONS
-# to a value that should cause the JVM to use 70% of that memory size.
-# The JVM reads command-line options from this environment variable.
-if [[ -z "${JAVA_TOOL_OPTIONS+x}" && -n "${X_GOOGLE_MEMORY_HINT_DO_NOT_USE}" ]]
-then
-  export JAVA_TOOL_OPTIONS="-XX:MaxRAM=${X_GOOGLE_MEMORY_HINT_DO_NOT_USE}m -XX:MaxRAMPercentage=70"
+# Java launcher that sizes the JVM to the limits of the container.
+# Like a memory calculator, it divides the memory limit of the cgroup (v2 or v1)
+# into the stacks of $JAVA_THREAD_COUNT (default 100) threads of 1 MiB each, the
+# metaspace estimated from the number of classes of the Java runtime and of the
+# application ($JAVA_APP_CLASS_COUNT, counted at build time), the code cache
+# (128 MiB), direct memory (10 MiB), a headroom of $JAVA_HEAD_ROOM percent
+# (default 0) for other native memory, and the heap that gets the rest. The
+# number of CPUs is taken from the quota of the cgroup, and the class data
+# sharing archive created at build time, if any, is mapped to start faster.
+# The options are prepended to $JAVA_TOOL_OPTIONS, which the JVM reads, and an
+# option that $JAVA_TOOL_OPTIONS already sets is left to it.
+
+mib=$(( 1024 * 1024 ))
+# jvm_classes is the approximate number of classes of the Java runtime.
+jvm_classes=24000
+options=()
+
+# has returns whether $JAVA_TOOL_OPTIONS sets any of the given options.
+has() {
+  local option
+  for option in "$@"; do
+    if [[ " ${JAVA_TOOL_OPTIONS} " == *" ${option}"* ]]; then
+      return 0
+    fi
+  done
+  return 1
+}
+
+# number prints the value of a variable if it is a number between a minimum and
+# a maximum, and the default otherwise.
+number() {
+  local name="$1" default="$2" min="$3" max="$4"
+  local value="${!name:-${default}}"
+  if ! [[ "${value}" =~ ^[0-9]+$ ]] || (( value < min || value > max )); then
+    echo "Ignoring invalid ${name}=${value}, using ${default}." >&2
+    value="${default}"
+  fi
+  echo "${value}"
+}
+
+limit=""
+if [[ -r /sys/fs/cgroup/memory.max ]]; then
+  limit="$(cat /sys/fs/cgroup/memory.max)"
+elif [[ -r /sys/fs/cgroup/memory/memory.limit_in_bytes ]]; then
+  limit="$(cat /sys/fs/cgroup/memory/memory.limit_in_bytes)"
+fi
+# cgroup v2 reports "max" without a limit; cgroup v1 reports a value close to
+# the largest 64-bit integer, so treat anything above 1 TiB as unlimited.
+if [[ "${limit}" =~ ^[0-9]+$ ]] && (( limit < 1 << 40 )); then
+  threads="$(number JAVA_THREAD_COUNT 100 1 100000)"
+  headroom="$(number JAVA_HEAD_ROOM 0 0 99)"
+  classes="$(number JAVA_APP_CLASS_COUNT 0 0 10000000)"
+
+  # The JVM loads about 35% of the classes, each of which takes about 5800 bytes
+  # of metaspace, on top of 14 MB of its own.
+  metaspace=$(( (jvm_classes + classes) * 35 / 100 * 5800 + 14000000 ))
+  stacks=$(( threads * mib ))
+  code_cache=$(( 128 * mib ))
+  direct=$(( 10 * mib ))
+  heap=$(( limit - limit * headroom / 100 - stacks - metaspace - code_cache - direct ))
+
+  if (( heap < 32 * mib )); then
+    echo "The memory limit of $(( limit / mib ))M is too low for the stacks of ${threads} threads, $(( metaspace / mib ))M of metaspace, 128M of code cache and 10M of direct memory; the heap uses 75% of it instead." >&2
+    has -Xmx -XX:MaxHeapSize= -XX:MaxRAMPercentage= || options+=("-XX:MaxRAMPercentage=75")
+  else
+    has -Xmx -XX:MaxHeapSize= -XX:MaxRAMPercentage= || options+=("-Xmx$(( heap / mib ))M")
+    has -XX:MaxMetaspaceSize= || options+=("-XX:MaxMetaspaceSize=$(( metaspace / mib ))M")
+    has -Xss -XX:ThreadStackSize= || options+=("-Xss1M")
+    has -XX:ReservedCodeCacheSize= || options+=("-XX:ReservedCodeCacheSize=128M")
+    has -XX:MaxDirectMemorySize= || options+=("-XX:MaxDirectMemorySize=10M")
+    echo "JVM memory for a limit of $(( limit / mib ))M: $(( heap / mib ))M heap, $(( metaspace / mib ))M metaspace, ${threads} threads of 1M, 128M code cache, 10M direct memory, ${headroom}% headroom." >&2
+  fi
+fi
+
+quota=""
+period=""
+if [[ -r /sys/fs/cgroup/cpu.max ]]; then
+  read -r quota period < /sys/fs/cgroup/cpu.max
+elif [[ -r /sys/fs/cgroup/cpu/cpu.cfs_quota_us && -r /sys/fs/cgroup/cpu/cpu.cfs_period_us ]]; then
+  quota="$(cat /sys/fs/cgroup/cpu/cpu.cfs_quota_us)"
+  period="$(cat /sys/fs/cgroup/cpu/cpu.cfs_period_us)"
+fi
+# cgroup v2 reports "max" and cgroup v1 reports -1 without a quota.
+if [[ "${quota}" =~ ^[0-9]+$ && "${period}" =~ ^[0-9]+$ ]] && (( quota > 0 && period > 0 )); then
+  has -XX:ActiveProcessorCount= || options+=("-XX:ActiveProcessorCount=$(( (quota + period - 1) / period ))")
+fi
+
+archive="$(dirname "$0")/functions-framework.jsa"
+if [[ -f "${archive}" ]]; then
+  has -XX:SharedArchiveFile= -Xshare: || options+=("-XX:SharedArchiveFile=${archive}" "-Xshare:auto")
+fi
+
+if (( ${#options[@]} > 0 )); then
+  export JAVA_TOOL_OPTIONS="${options[*]}${JAVA_TOOL_OPTIONS:+ ${JAVA_TOOL_OPTIONS}}"
 fi
 exec "$@"
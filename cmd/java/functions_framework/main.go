@@ -24,18 +24,13 @@ import (
 
 	"github.com/GoogleCloudPlatform/buildpacks/pkg/env"
 	gcp "github.com/GoogleCloudPlatform/buildpacks/pkg/gcpbuildpack"
-	"github.com/beevik/etree"
-	"github.com/buildpacks/libcnb"
+	"github.com/GoogleCloudPlatform/buildpacks/pkg/java"
 )
 
 const (
 	layerName = "functions-framework"
-
-	defaultMavenRepository         = "https://repo.maven.apache.org/maven2/"
-	defaultMavenSnapshotRepository = "https://s01.oss.sonatype.org/content/repositories/snapshots"
-	defaultFrameworkGroup          = "dev.openfunction.functions"
-	defaultFrameworkArtifactID     = "functions-framework-invoker"
-	defaultFrameworkVersion        = "1.2.0"
+	// gradleClasspathTask is the task of the classpath.gradle init script that reports the classpath.
+	gradleClasspathTask = "_javaFunctionPrintClasspath"
 )
 
 func main() {
@@ -50,8 +45,7 @@ func detectFn(_ *gcp.Context) (gcp.DetectResult, error) {
 }
 
 func buildFn(ctx *gcp.Context) error {
-	layer := ctx.Layer(layerName)
-	layer.Launch = true
+	layer := ctx.Layer(layerName, gcp.CacheLayer, gcp.LaunchLayer)
 
 	if err := installFunctionsFramework(ctx, layer); err != nil {
 		return err
@@ -70,10 +64,16 @@ func buildFn(ctx *gcp.Context) error {
 		return gcp.UserErrorf("build succeeded but did not produce the target classes")
 	}
 
+	frameworkJar := filepath.Join(layer.Path, "functions-framework.jar")
+	if err := configureJVM(ctx, layer, frameworkJar, classpath); err != nil {
+		return err
+	}
+
+	// The launcher sizes the JVM to the limits of the container.
 	launcherSource := filepath.Join(ctx.BuildpackRoot(), "launch.sh")
 	launcherTarget := filepath.Join(layer.Path, "launch.sh")
 	createLauncher(ctx, launcherSource, launcherTarget)
-	ctx.AddDefaultWebProcess([]string{launcherTarget, "java", "-jar", filepath.Join(layer.Path, "functions-framework.jar")}, true)
+	ctx.AddDefaultWebProcess([]string{launcherTarget, "java", "-jar", frameworkJar}, true)
 
 	return nil
 }
@@ -109,7 +109,7 @@ func classpath(ctx *gcp.Context) (string, error) {
 	if ctx.FileExists("pom.xml") {
 		return mavenClasspath(ctx)
 	}
-	if ctx.FileExists("build.gradle") {
+	if ctx.FileExists("build.gradle") || ctx.FileExists("build.gradle.kts") {
 		return gradleClasspath(ctx)
 	}
 	jars := ctx.Glob("*.jar")
@@ -129,7 +129,8 @@ func classpath(ctx *gcp.Context) (string, error) {
 }
 
 // mavenClasspath determines the --classpath when there is a pom.xml. This will consist of the jar file built
-// from the pom.xml itself, plus all jar files that are dependencies mentioned in the pom.xml.
+// from the pom.xml itself, plus all jar files that are dependencies mentioned in the pom.xml. In a multi-module
+// project, the pom.xml is the one of the module selected by FUNC_BUILDABLE.
 func mavenClasspath(ctx *gcp.Context) (string, error) {
 	mvn := "mvn"
 	// If this project has the Maven Wrapper, we should use it
@@ -137,143 +138,146 @@ func mavenClasspath(ctx *gcp.Context) (string, error) {
 		mvn = "./mvnw"
 	}
 
-	// Extract the artifact/version coordinates from the user's pom.xml definitions.
+	// Extract the build directory and the final name from the user's pom.xml definitions.
 	// mvn help:evaluate is quite slow so we do it this way rather than calling it twice.
-	// The name of the built jar file will be <artifact>-<version>.jar, for example myfunction-0.9.jar.
-	execResult := ctx.Exec([]string{mvn, "help:evaluate", "-q", "-DforceStdout", "-Dexpression=project.artifactId/${project.version}"}, gcp.WithUserAttribution)
-	groupArtifactVersion := execResult.Stdout
-	components := strings.Split(groupArtifactVersion, "/")
-	if len(components) != 2 {
-		return "", gcp.UserErrorf("could not parse query output into artifact/version: %s", groupArtifactVersion)
+	// The name of the built jar file will be <finalName>.jar, by default <artifact>-<version>.jar, for example
+	// myfunction-0.9.jar.
+	command := []string{mvn, "help:evaluate", "-q", "-DforceStdout", "-Dexpression=${project.build.directory}/${project.build.finalName}"}
+	module := java.MavenModule()
+	if module != "" {
+		command = append(command, "--projects", module)
+	}
+	execResult := ctx.Exec(command, gcp.WithUserAttribution)
+	target, finalName := filepath.Split(strings.TrimSpace(execResult.Stdout))
+	if target == "" || finalName == "" || strings.Contains(finalName, "${") {
+		return "", gcp.UserErrorf("could not parse query output into build directory/final name: %s", execResult.Stdout)
 	}
-	artifact, version := components[0], components[1]
-	jarName := fmt.Sprintf("target/%s-%s.jar", artifact, version)
+	target, err := filepath.Rel(ctx.ApplicationRoot(), target)
+	if err != nil {
+		return "", gcp.InternalErrorf("finding the path of %s in %s: %v", target, ctx.ApplicationRoot(), err)
+	}
+	jarName := filepath.Join(target, finalName+".jar")
 	if !ctx.FileExists(jarName) {
+		if module == "" && len(ctx.Glob("*/pom.xml")) > 0 {
+			return "", gcp.UserErrorf("expected output jar %s does not exist, set %s to the module of the function if this is a multi-module project", jarName, env.Buildable)
+		}
 		return "", gcp.UserErrorf("expected output jar %s does not exist", jarName)
 	}
 
 	// The Functions Framework understands "*" to mean every jar file in that directory.
 	// So this classpath consists of the just-built jar and all of the dependency jars.
-	return jarName + ":target/dependency/*", nil
+	return fmt.Sprintf("%s:%s/*", jarName, filepath.Join(target, "dependency")), nil
 }
 
-// gradleClasspath determines the --classpath when there is a build.gradle. This will consist of the jar file built
-// from the build.gradle, plus all jar files that are dependencies mentioned there.
-// Unlike Maven, Gradle doesn't have a simple way to query the contents of the build.gradle. But we can update
-// the user's build.gradle to append tasks that do that. This is a bit ugly, but using --init-script didn't work
-// because apparently you can't define tasks there; and having the predefined script include the user's build.gradle
-// didn't work very well either, because you can't use a plugins {} clause in an included script.
+// gradleClasspath determines the --classpath when there is a Gradle build. This will consist of the jar file built
+// by the function's project, plus all jar files that are its runtime dependencies. The classpath is reported by the
+// tasks of an init script, so that the user's build files are left untouched. In a multi-project build, the project
+// of the function is the root project, the only project that applies the java plugin, or the one set in
+// FUNC_BUILDABLE, either as a project path such as `:function` or as a directory such as `function`.
 func gradleClasspath(ctx *gcp.Context) (string, error) {
-	extraTasksSource := filepath.Join(ctx.BuildpackRoot(), "extra_tasks.gradle")
-	extraTasksText := ctx.ReadFile(extraTasksSource)
-	if err := os.Chmod("build.gradle", 0644); err != nil {
-		return "", gcp.InternalErrorf("making build.gradle writable: %v", err)
-	}
-	f, err := os.OpenFile("build.gradle", os.O_APPEND|os.O_WRONLY, 0600)
-	if err != nil {
-		return "", gcp.InternalErrorf("opening build.gradle for appending: %v", err)
+	// The gradle buildpack exports GRADLE_USER_HOME, so the dependencies that it resolved are reused.
+	gradle := "gradle"
+	if ctx.FileExists("gradlew") {
+		gradle = "./gradlew"
 	}
-	defer func() {
-		_ = f.Close()
-	}()
-	if _, err := f.Write(extraTasksText); err != nil {
-		return "", gcp.InternalErrorf("appending extra definitions to build.gradle: %v", err)
+	task := gradleClasspathTask
+	if buildable := os.Getenv(env.Buildable); buildable != "" {
+		task = strings.TrimSuffix(gradleProjectPath(buildable), ":") + ":" + gradleClasspathTask
 	}
 
-	// Copy the dependencies of the function (`dependencies {...}` in build.gradle) into build/_javaFunctionDependencies.
-	ctx.Exec([]string{"gradle", "--quiet", "_javaFunctionCopyAllDependencies"}, gcp.WithUserAttribution)
+	initScript := filepath.Join(ctx.BuildpackRoot(), "classpath.gradle")
+	execResult := ctx.Exec([]string{gradle, "--quiet", "--init-script", initScript, task}, gcp.WithUserAttribution)
+	reports := parseClasspathReports(execResult.Stdout)
+	report, err := functionProject(reports)
+	if err != nil {
+		return "", err
+	}
 
-	// Extract the name of the target jar.
-	execResult := ctx.Exec([]string{"gradle", "--quiet", "_javaFunctionPrintJarTarget"}, gcp.WithUserAttribution)
-	jarName := strings.TrimSpace(execResult.Stdout)
+	jarName, err := filepath.Rel(ctx.ApplicationRoot(), report.jar)
+	if err != nil {
+		return "", gcp.InternalErrorf("finding the path of %s in %s: %v", report.jar, ctx.ApplicationRoot(), err)
+	}
 	if !ctx.FileExists(jarName) {
 		return "", gcp.UserErrorf("expected output jar %s does not exist", jarName)
 	}
+	dependencies, err := filepath.Rel(ctx.ApplicationRoot(), report.dependencies)
+	if err != nil {
+		return "", gcp.InternalErrorf("finding the path of %s in %s: %v", report.dependencies, ctx.ApplicationRoot(), err)
+	}
 
 	// The Functions Framework understands "*" to mean every jar file in that directory.
 	// So this classpath consists of the just-built jar and all of the dependency jars.
-	return fmt.Sprintf("%s:build/_javaFunctionDependencies/*", jarName), nil
+	return fmt.Sprintf("%s:%s/*", jarName, dependencies), nil
 }
 
-func installFunctionsFramework(ctx *gcp.Context, layer *libcnb.Layer) error {
+// classpathReport is the classpath of a Gradle project, as printed by the init script.
+type classpathReport struct {
+	project      string
+	jar          string
+	dependencies string
+}
 
-	ffName := filepath.Join(layer.Path, "functions-framework.jar")
-	if jarPath, ok := os.LookupEnv(env.FunctionFrameworkJar); ok {
-		if strings.HasPrefix(jarPath, "http") || strings.HasPrefix(jarPath, "https") {
-			return downloadFramework(ctx, ffName, jarPath)
-		} else {
-			_, err := ctx.ExecWithErr([]string{"bash", "-c", fmt.Sprintf("mv %s %s", jarPath, ffName)})
-			return err
+// parseClasspathReports returns the classpath reports in the output of the init script's task, one per project.
+// Other lines, such as the output of the user's build scripts, are ignored.
+func parseClasspathReports(output string) []classpathReport {
+	var reports []classpathReport
+	for _, line := range strings.Split(output, "\n") {
+		key, value, found := cut(strings.TrimSpace(line), "=")
+		if !found {
+			continue
+		}
+		switch key {
+		case "_javaFunctionProject":
+			reports = append(reports, classpathReport{project: value})
+		case "_javaFunctionJar":
+			if len(reports) > 0 {
+				reports[len(reports)-1].jar = value
+			}
+		case "_javaFunctionDependencies":
+			if len(reports) > 0 {
+				reports[len(reports)-1].dependencies = value
+			}
 		}
 	}
+	return reports
+}
 
-	frameworkGroup := os.Getenv(env.FunctionFrameworkGroup)
-	if frameworkGroup == "" {
-		frameworkGroup = defaultFrameworkGroup
-	}
-	frameworkGroup = strings.ReplaceAll(frameworkGroup, ".", "/")
-
-	frameworkArtifactID := os.Getenv(env.FunctionFrameworkArtifactID)
-	if frameworkArtifactID == "" {
-		frameworkArtifactID = defaultFrameworkArtifactID
-	}
-
-	frameworkVersion := os.Getenv(env.FunctionFrameworkVersion)
-	if frameworkVersion == "" {
-		frameworkVersion = defaultFrameworkVersion
+// functionProject returns the report of the project of the function: the only project reported, or else the root
+// project.
+func functionProject(reports []classpathReport) (classpathReport, error) {
+	if len(reports) == 1 {
+		return reports[0], nil
 	}
-
-	mavenRepository := os.Getenv(env.MavenRepository)
-	if mavenRepository == "" {
-		mavenRepository = defaultMavenRepository
-		if strings.HasSuffix(frameworkVersion, "-SNAPSHOT") {
-			mavenRepository = defaultMavenSnapshotRepository
+	var projects []string
+	for _, r := range reports {
+		if r.project == ":" {
+			return r, nil
 		}
+		projects = append(projects, r.project)
 	}
-
-	artifact := fmt.Sprintf("%s-jar-with-dependencies.jar", frameworkArtifactID)
-	version := frameworkVersion
-	if strings.HasSuffix(frameworkVersion, "-SNAPSHOT") {
-		var err error
-		version, err = getSnapshotVersion(ctx, mavenRepository, frameworkGroup, frameworkArtifactID, frameworkVersion)
-		if err != nil {
-			return err
-		}
+	if len(projects) == 0 {
+		return classpathReport{}, gcp.UserErrorf("no Gradle project of the function applies the java plugin")
 	}
-
-	artifact = fmt.Sprintf("%s-%s-jar-with-dependencies.jar", frameworkArtifactID, version)
-	url := fmt.Sprintf("%s/%s/%s/%s/%s", mavenRepository, frameworkGroup, frameworkArtifactID, frameworkVersion, artifact)
-
-	return downloadFramework(ctx, ffName, strings.ReplaceAll(url, "//", "/"))
+	return classpathReport{}, gcp.UserErrorf("found several Gradle projects that apply the java plugin: %s, please set %s to the project of the function", strings.Join(projects, ", "), env.Buildable)
 }
 
-func downloadFramework(ctx *gcp.Context, name, url string) error {
-	_, err := ctx.ExecWithErr([]string{"curl", "--silent", "--fail", "--show-error", "--output", name, url})
-	if err != nil {
-		return gcp.InternalErrorf("fetching functions framework jar[%s]: %s", url, err.Error())
+// gradleProjectPath returns the Gradle project path of a buildable, which is either already a project path or
+// the directory of the project relative to the root project, e.g. `:functions:hello` for `./functions/hello`.
+func gradleProjectPath(buildable string) string {
+	if strings.HasPrefix(buildable, ":") {
+		return buildable
 	}
-
-	ctx.Logf("fetching functions framework jar from %s", url)
-
-	return nil
-}
-
-func getSnapshotVersion(ctx *gcp.Context, mavenRepository, frameworkGroup, frameworkArtifactID, frameworkVersion string) (string, error) {
-
-	url := fmt.Sprintf("%s/%s/%s/%s/maven-metadata.xml", mavenRepository, frameworkGroup, frameworkArtifactID, frameworkVersion)
-	if _, err := ctx.ExecWithErr([]string{"curl", "--silent", "--fail", "--show-error", "--output", "/tmp/maven-metadata.xml", url}); err != nil {
-		return "", gcp.InternalErrorf("fetching functions framework metadata[%s]: %s", url, err.Error())
-	}
-
-	doc := etree.NewDocument()
-	if err := doc.ReadFromFile("/tmp/maven-metadata.xml"); err != nil {
-		return "", gcp.InternalErrorf("parse functions framework metadata[%s]: %s", url, err.Error())
+	dir := filepath.ToSlash(filepath.Clean(buildable))
+	if dir == "." {
+		return ":"
 	}
+	return ":" + strings.ReplaceAll(strings.Trim(dir, "/"), "/", ":")
+}
 
-	element := doc.FindElement("//metadata/versioning/snapshotVersions/snapshotVersion[classifier='jar-with-dependencies'][extension='jar']/value")
-	if element != nil {
-		return element.Text(), nil
+// cut slices s around the first instance of sep, returning the text before and after sep.
+func cut(s, sep string) (string, string, bool) {
+	if i := strings.Index(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
 	}
-
-	return "", nil
+	return s, "", false
 }
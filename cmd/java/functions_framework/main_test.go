@@ -15,9 +15,16 @@
 package main
 
 import (
+	"archive/zip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
 	"testing"
 
+	"github.com/GoogleCloudPlatform/buildpacks/pkg/env"
 	gcp "github.com/GoogleCloudPlatform/buildpacks/pkg/gcpbuildpack"
+	"github.com/buildpacks/libcnb"
 )
 
 func TestDetect(t *testing.T) {
@@ -44,3 +51,291 @@ func TestDetect(t *testing.T) {
 		})
 	}
 }
+
+func TestParseClasspathReports(t *testing.T) {
+	output := `Configuring the build.
+_javaFunctionProject=:
+_javaFunctionJar=/workspace/build/libs/app-1.0.jar
+_javaFunctionDependencies=/workspace/build/_javaFunctionDependencies
+_javaFunctionProject=:lib
+_javaFunctionJar=/workspace/lib/build/libs/lib.jar
+_javaFunctionDependencies=/workspace/lib/build/_javaFunctionDependencies
+`
+	want := []classpathReport{
+		{project: ":", jar: "/workspace/build/libs/app-1.0.jar", dependencies: "/workspace/build/_javaFunctionDependencies"},
+		{project: ":lib", jar: "/workspace/lib/build/libs/lib.jar", dependencies: "/workspace/lib/build/_javaFunctionDependencies"},
+	}
+	if got := parseClasspathReports(output); !reflect.DeepEqual(got, want) {
+		t.Errorf("parseClasspathReports() = %+v, want %+v", got, want)
+	}
+}
+
+func TestFunctionProject(t *testing.T) {
+	testCases := []struct {
+		name    string
+		reports []classpathReport
+		want    string
+		wantErr bool
+	}{
+		{
+			name:    "single project",
+			reports: []classpathReport{{project: ":function"}},
+			want:    ":function",
+		},
+		{
+			name:    "root project",
+			reports: []classpathReport{{project: ":lib"}, {project: ":"}},
+			want:    ":",
+		},
+		{
+			name:    "several subprojects",
+			reports: []classpathReport{{project: ":lib"}, {project: ":function"}},
+			wantErr: true,
+		},
+		{
+			name:    "no project",
+			wantErr: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := functionProject(tc.reports)
+			if gotErr := err != nil; gotErr != tc.wantErr {
+				t.Fatalf("functionProject() got error: %v, want error: %t", err, tc.wantErr)
+			}
+			if got.project != tc.want {
+				t.Errorf("functionProject() = %q, want %q", got.project, tc.want)
+			}
+		})
+	}
+}
+
+func TestGradleProjectPath(t *testing.T) {
+	testCases := []struct {
+		buildable string
+		want      string
+	}{
+		{buildable: ":functions:hello", want: ":functions:hello"},
+		{buildable: "./functions/hello", want: ":functions:hello"},
+		{buildable: "function/", want: ":function"},
+		{buildable: ".", want: ":"},
+	}
+	for _, tc := range testCases {
+		if got := gradleProjectPath(tc.buildable); got != tc.want {
+			t.Errorf("gradleProjectPath(%q) = %q, want %q", tc.buildable, got, tc.want)
+		}
+	}
+}
+
+func TestFrameworkRepository(t *testing.T) {
+	testCases := []struct {
+		name    string
+		value   string
+		version string
+		want    repository
+		wantErr bool
+	}{
+		{
+			name:    "default",
+			version: "1.2.0",
+			want:    repository{id: defaultRepositoryID, url: defaultMavenRepository},
+		},
+		{
+			name:    "default snapshot",
+			version: "1.3.0-SNAPSHOT",
+			want:    repository{id: defaultRepositoryID, url: defaultMavenSnapshotRepository},
+		},
+		{
+			name:    "url",
+			value:   "https://maven.example.com/releases/",
+			version: "1.2.0",
+			want:    repository{id: defaultRepositoryID, url: "https://maven.example.com/releases"},
+		},
+		{
+			name:    "id and url",
+			value:   "private::https://maven.example.com/releases",
+			version: "1.2.0",
+			want:    repository{id: "private", url: "https://maven.example.com/releases"},
+		},
+		{
+			name:    "not a url",
+			value:   "maven.example.com",
+			version: "1.2.0",
+			wantErr: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := os.Setenv(env.MavenRepository, tc.value); err != nil {
+				t.Fatalf("Failed to set env: %v", err)
+			}
+			defer func() {
+				if err := os.Unsetenv(env.MavenRepository); err != nil {
+					t.Fatalf("Failed to unset env: %v", err)
+				}
+			}()
+
+			got, err := frameworkRepository(coordinates{version: tc.version})
+			if gotErr := err != nil; gotErr != tc.wantErr {
+				t.Fatalf("frameworkRepository() got error: %v, want error: %t", err, tc.wantErr)
+			}
+			if got != tc.want {
+				t.Errorf("frameworkRepository() = %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestInstallFunctionsFrameworkJar(t *testing.T) {
+	d, err := ioutil.TempDir("", "test-install-functions-framework-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(d)
+	jar := filepath.Join(d, "invoker.jar")
+	if err := ioutil.WriteFile(jar, []byte("local"), 0644); err != nil {
+		t.Fatalf("Failed to write %s: %v", jar, err)
+	}
+	if err := os.Setenv(env.FunctionFrameworkJar, jar); err != nil {
+		t.Fatalf("Failed to set env: %v", err)
+	}
+	defer func() {
+		if err := os.Unsetenv(env.FunctionFrameworkJar); err != nil {
+			t.Fatalf("Failed to unset env: %v", err)
+		}
+	}()
+
+	// The layer holds the jar resolved by a previous build from the default coordinates.
+	c := frameworkCoordinates()
+	layer := &libcnb.Layer{
+		Name:     layerName,
+		Path:     filepath.Join(d, "layer"),
+		Metadata: map[string]interface{}{coordinatesKey: c.String() + "@" + defaultMavenRepository},
+	}
+	ctx := gcp.NewContextForTests(libcnb.BuildpackInfo{}, d)
+	if err := installFunctionsFramework(ctx, layer); err != nil {
+		t.Fatalf("installFunctionsFramework() got error: %v", err)
+	}
+
+	if got := ctx.GetMetadata(layer, coordinatesKey); got != "" {
+		t.Errorf("installFunctionsFramework() left %s metadata %q, want none", coordinatesKey, got)
+	}
+	content, err := ioutil.ReadFile(filepath.Join(layer.Path, "functions-framework.jar"))
+	if err != nil {
+		t.Fatalf("Failed to read installed jar: %v", err)
+	}
+	if string(content) != "local" {
+		t.Errorf("installed jar = %q, want the jar of %s", content, env.FunctionFrameworkJar)
+	}
+}
+
+func TestCopyCommand(t *testing.T) {
+	c := coordinates{group: "dev.openfunction.functions", artifact: "functions-framework-invoker", version: "1.2.0", classifier: frameworkClassifier}
+	repo := repository{id: "private", url: "https://maven.example.com/releases"}
+
+	got := copyCommand(c, repo, "/layers/functions-framework", true, true)
+	want := []string{
+		"mvn", "--batch-mode", "--quiet", "--strict-checksums", "--settings", "settings.xml", "--offline",
+		dependencyPlugin + ":copy",
+		"-Dartifact=dev.openfunction.functions:functions-framework-invoker:1.2.0:jar:jar-with-dependencies",
+		"-DremoteRepositories=private::default::https://maven.example.com/releases",
+		"-DoutputDirectory=/layers/functions-framework",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("copyCommand() = %q, want %q", got, want)
+	}
+}
+
+func TestMavenSettings(t *testing.T) {
+	testCases := []struct {
+		name     string
+		bindings libcnb.Bindings
+		want     string
+		wantErr  bool
+	}{
+		{
+			name: "no bindings",
+		},
+		{
+			name: "other binding types",
+			bindings: libcnb.Bindings{
+				{Name: "npm", Type: "npmrc", Secret: map[string]string{".npmrc": "always-auth=true"}},
+			},
+		},
+		{
+			name: "settings.xml",
+			bindings: libcnb.Bindings{
+				{Name: "maven", Type: "maven", Secret: map[string]string{"settings.xml": "<settings/>"}},
+			},
+			want: "<settings/>",
+		},
+		{
+			name: "missing settings.xml",
+			bindings: libcnb.Bindings{
+				{Name: "maven", Type: "maven", Secret: map[string]string{"username": "user"}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "several settings.xml",
+			bindings: libcnb.Bindings{
+				{Name: "a", Type: "maven", Secret: map[string]string{"settings.xml": "<settings/>"}},
+				{Name: "b", Type: "maven", Secret: map[string]string{"settings.xml": "<settings/>"}},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := mavenSettings(tc.bindings)
+			if gotErr := err != nil; gotErr != tc.wantErr {
+				t.Fatalf("mavenSettings() got error: %v, want error: %t", err, tc.wantErr)
+			}
+			if got != tc.want {
+				t.Errorf("mavenSettings() = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestClassNames(t *testing.T) {
+	dir, err := ioutil.TempDir("", "class-names-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	jar := filepath.Join(dir, "function.jar")
+	f, err := os.Create(jar)
+	if err != nil {
+		t.Fatalf("Failed to create %s: %v", jar, err)
+	}
+	w := zip.NewWriter(f)
+	for _, name := range []string{
+		"META-INF/MANIFEST.MF",
+		"META-INF/versions/11/com/example/Util.class",
+		"module-info.class",
+		"com/example/",
+		"com/example/HelloWorld.class",
+		"com/example/HelloWorld$1.class",
+		"com/example/messages.properties",
+	} {
+		if _, err := w.Create(name); err != nil {
+			t.Fatalf("Failed to add %s to %s: %v", name, jar, err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Failed to write %s: %v", jar, err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("Failed to close %s: %v", jar, err)
+	}
+
+	got, err := classNames(jar)
+	if err != nil {
+		t.Fatalf("classNames() got error: %v", err)
+	}
+	want := []string{"com/example/HelloWorld", "com/example/HelloWorld$1"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("classNames() = %q, want %q", got, want)
+	}
+}
@@ -26,12 +26,15 @@ import (
 	"github.com/GoogleCloudPlatform/buildpacks/pkg/devmode"
 	"github.com/GoogleCloudPlatform/buildpacks/pkg/env"
 	gcp "github.com/GoogleCloudPlatform/buildpacks/pkg/gcpbuildpack"
+	"github.com/GoogleCloudPlatform/buildpacks/pkg/java"
 )
 
 const (
+	gradleLayer          = "gradle"
 	defaultGradleVersion = "7.4.2"
 	gradleDistroURL      = "https://services.gradle.org/distributions/gradle-%s-bin.zip"
 	gradlePath           = "/usr/local/gradle"
+	gradleUserHome       = "GRADLE_USER_HOME"
 )
 
 func main() {
@@ -49,6 +52,21 @@ func detectFn(ctx *gcp.Context) (gcp.DetectResult, error) {
 }
 
 func buildFn(ctx *gcp.Context) error {
+	// The Gradle user home holds the dependency caches, the distributions downloaded by the Gradle
+	// Wrapper and the local build cache. It is exported to the subsequent buildpacks, so that they
+	// reuse the dependencies resolved by this build.
+	gradleHome := ctx.Layer(gradleLayer, gcp.BuildLayer, gcp.CacheLayer)
+	java.CheckCacheExpiration(ctx, gradleHome)
+	if ctx.FileExists(gradleHome.Path, "caches") || ctx.FileExists(gradleHome.Path, "wrapper") {
+		ctx.CacheHit(gradleLayer)
+	} else {
+		ctx.CacheMiss(gradleLayer)
+	}
+	gradleHome.BuildEnvironment.Override(gradleUserHome, gradleHome.Path)
+	if err := os.Setenv(gradleUserHome, gradleHome.Path); err != nil {
+		return gcp.InternalErrorf("setting %s: %v", gradleUserHome, err)
+	}
+
 	var gradle string
 	if ctx.FileExists("gradlew") {
 		gradle = "./gradlew"
@@ -64,11 +82,19 @@ func buildFn(ctx *gcp.Context) error {
 
 	ctx.Exec([]string{gradle, "-v"}, gcp.WithUserAttribution)
 
-	command := []string{gradle, "clean", "assemble", "-x", "test", "--build-cache"}
+	command := []string{gradle, "clean", "assemble", "-x", "test"}
+
+	buildCache, err := env.IsGradleBuildCache()
+	if err != nil {
+		return gcp.UserErrorf("failed to parse %s: %v", env.GradleBuildCache, err)
+	}
+	if buildCache {
+		command = append(command, "--build-cache")
+	}
 
 	if buildArgs := os.Getenv(env.BuildArgs); buildArgs != "" {
-		if strings.Contains(buildArgs, "project-cache-dir") {
-			ctx.Warnf("Detected project-cache-dir property set in GOOGLE_BUILD_ARGS. Dependency caching may not work properly.")
+		if strings.Contains(buildArgs, "project-cache-dir") || strings.Contains(buildArgs, "gradle-user-home") {
+			ctx.Warnf("Detected project-cache-dir or gradle-user-home property set in %s. Dependency caching may not work properly.", env.BuildArgs)
 		}
 		command = append(command, buildArgs)
 	}
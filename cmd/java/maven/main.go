@@ -82,6 +82,12 @@ func buildFn(ctx *gcp.Context) error {
 
 	command := []string{mvn, "clean", "package", "dependency:copy-dependencies", "--batch-mode", "-DskipTests", "-Dhttp.keepAlive=false"}
 
+	// In a multi-module project, build only the selected module and the modules it depends on.
+	if module := java.MavenModule(); module != "" {
+		ctx.Logf("Building Maven module %s selected by %s.", module, env.Buildable)
+		command = append(command, "--projects", module, "--also-make")
+	}
+
 	if buildArgs := os.Getenv(env.BuildArgs); buildArgs != "" {
 		if strings.Contains(buildArgs, "maven.repo.local") {
 			ctx.Warnf("Detected maven.repo.local property set in GOOGLE_BUILD_ARGS. Maven caching may not work properly.")
@@ -89,6 +95,14 @@ func buildFn(ctx *gcp.Context) error {
 		command = append(command, buildArgs)
 	}
 
+	offline, err := env.IsMavenOffline()
+	if err != nil {
+		return gcp.UserErrorf("failed to parse %s: %v", env.MavenOffline, err)
+	}
+	if offline {
+		command = append(command, "--offline")
+	}
+
 	if !ctx.Debug() && !devmode.Enabled(ctx) {
 		command = append(command, "--quiet")
 	}
@@ -0,0 +1,309 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	gcp "github.com/GoogleCloudPlatform/buildpacks/pkg/gcpbuildpack"
+)
+
+const (
+	temurinURL      = "https://api.adoptium.net/v3/assets/feature_releases/%s/ga?architecture=x64&heap_size=normal&image_type=%s&jvm_impl=hotspot&os=linux&page=0&page_size=1&project=jdk&sort_order=DESC&vendor=eclipse"
+	zuluPackagesURL = "https://api.azul.com/metadata/v1/zulu/packages/?java_version=%s&os=linux&arch=x64&archive_type=tar.gz&java_package_type=%s&javafx_bundled=false&latest=true&release_status=ga&availability_types=CA&page=1&page_size=1"
+	zuluPackageURL  = "https://api.azul.com/metadata/v1/zulu/packages/%s"
+	correttoURL     = "https://corretto.aws/downloads/latest/amazon-corretto-%s-x64-linux-%s.tar.gz"
+	correttoSHA256  = "https://corretto.aws/downloads/resources/%s/%s.sha256"
+	libericaURL     = "https://api.bell-sw.com/v1/liberica/releases?version-feature=%s&version-modifier=latest&bitness=64&os=linux&arch=x86&package-type=tar.gz&bundle-type=%s"
+
+	defaultDistribution = "temurin"
+	sha256Algorithm     = "sha256"
+	sha1Algorithm       = "sha1"
+)
+
+var (
+	// javaVersionRegexp matches the version in the release file of a JDK or JRE, e.g. `JAVA_VERSION="11.0.17"`.
+	javaVersionRegexp = regexp.MustCompile(`(?m)^JAVA_VERSION="([^"]+)"`)
+	// releaseEntryRegexp and javacEntryRegexp match the release file and javac in the listing of a tarball.
+	releaseEntryRegexp = regexp.MustCompile(`(?m)^((?:\./)?[^/]+/release)$`)
+	javacEntryRegexp   = regexp.MustCompile(`(?m)^(?:\./)?[^/]+/bin/javac$`)
+	// correttoResourceRegexp matches the versioned archive that the latest Corretto URL redirects to, e.g.
+	// `https://corretto.aws/downloads/resources/17.0.5.8.1/amazon-corretto-17.0.5.8.1-linux-x64.tar.gz`.
+	correttoResourceRegexp = regexp.MustCompile(`/downloads/resources/([^/]+)/([^/]+\.tar\.gz)$`)
+	// sha256Regexp matches a hex SHA-256 digest.
+	sha256Regexp = regexp.MustCompile(`\b[0-9a-fA-F]{64}\b`)
+)
+
+// release is a JDK or JRE archive of a Java distribution.
+type release struct {
+	// version is empty if the distribution does not report it, it is then read from the release file
+	// of the installed archive.
+	version string
+	// url is the archive to download, path is a local archive.
+	url  string
+	path string
+	// algorithm and checksum are the published digest of the archive.
+	algorithm string
+	checksum  string
+}
+
+// resolvers return the latest release of a Java feature version from each distribution for the image
+// type, "jdk" or "jre", or nil if the distribution does not publish one.
+var resolvers = map[string]func(ctx *gcp.Context, feature, imageType string) (*release, error){
+	"temurin":  temurinRelease,
+	"zulu":     zuluRelease,
+	"corretto": correttoRelease,
+	"liberica": libericaRelease,
+}
+
+// distributions returns the names of the supported distributions.
+func distributions() []string {
+	var names []string
+	for name := range resolvers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func temurinRelease(ctx *gcp.Context, feature, imageType string) (*release, error) {
+	url := fmt.Sprintf(temurinURL, feature, imageType)
+	if code := ctx.HTTPStatus(url); code != http.StatusOK {
+		ctx.Debugf("Temurin %s %s is not available at %s (status %d).", imageType, feature, url, code)
+		return nil, nil
+	}
+	r, err := parseVersionJSON(fetch(ctx, url))
+	if err != nil {
+		return nil, fmt.Errorf("parsing JSON returned by %s: %w", url, err)
+	}
+	version, pkg, err := extractRelease(r, imageType)
+	if err != nil {
+		return nil, fmt.Errorf("extracting release returned by %s: %w", url, err)
+	}
+	return &release{version: version, url: pkg.Link, algorithm: sha256Algorithm, checksum: pkg.Checksum}, nil
+}
+
+// zuluPackage is a package in the responses of the Azul metadata API.
+type zuluPackage struct {
+	UUID        string `json:"package_uuid"`
+	JavaVersion []int  `json:"java_version"`
+	DownloadURL string `json:"download_url"`
+	SHA256      string `json:"sha256_hash"`
+}
+
+func zuluRelease(ctx *gcp.Context, feature, imageType string) (*release, error) {
+	url := fmt.Sprintf(zuluPackagesURL, feature, imageType)
+	var pkgs []zuluPackage
+	if err := json.Unmarshal([]byte(fetch(ctx, url)), &pkgs); err != nil {
+		return nil, fmt.Errorf("parsing JSON returned by %s: %w", url, err)
+	}
+	if len(pkgs) == 0 {
+		return nil, nil
+	}
+	// The list of packages does not include their checksums.
+	url = fmt.Sprintf(zuluPackageURL, pkgs[0].UUID)
+	var pkg zuluPackage
+	if err := json.Unmarshal([]byte(fetch(ctx, url)), &pkg); err != nil {
+		return nil, fmt.Errorf("parsing JSON returned by %s: %w", url, err)
+	}
+	version := make([]string, len(pkg.JavaVersion))
+	for i, v := range pkg.JavaVersion {
+		version[i] = strconv.Itoa(v)
+	}
+	return &release{version: strings.Join(version, "."), url: pkg.DownloadURL, algorithm: sha256Algorithm, checksum: pkg.SHA256}, nil
+}
+
+func correttoRelease(ctx *gcp.Context, feature, imageType string) (*release, error) {
+	// Corretto publishes JREs for Java 8 only.
+	if imageType == "jre" && feature != "8" {
+		return nil, nil
+	}
+	// The latest URL redirects to the archive of the exact version, whose checksum Corretto publishes
+	// next to it.
+	latestURL := fmt.Sprintf(correttoURL, feature, imageType)
+	result, err := ctx.ExecWithErr([]string{"curl", "--fail", "--silent", "--show-error", "--location", "--head", "--output", "/dev/null", "--write-out", "%{url_effective}", latestURL}, gcp.WithUserAttribution)
+	if err != nil {
+		ctx.Debugf("Corretto %s %s is not available at %s: %v", imageType, feature, latestURL, err)
+		return nil, nil
+	}
+	url := strings.TrimSpace(result.Stdout)
+	m := correttoResourceRegexp.FindStringSubmatch(url)
+	if m == nil {
+		return nil, fmt.Errorf("unexpected Corretto archive %q for %s", url, latestURL)
+	}
+	version, file := m[1], m[2]
+
+	checksumURL := fmt.Sprintf(correttoSHA256, version, file)
+	checksum := sha256Regexp.FindString(fetch(ctx, checksumURL))
+	if checksum == "" {
+		return nil, fmt.Errorf("no SHA-256 checksum of %s at %s", file, checksumURL)
+	}
+	return &release{version: version, url: url, algorithm: sha256Algorithm, checksum: checksum}, nil
+}
+
+// libericaJSON is a release in the responses of the BellSoft API.
+type libericaJSON struct {
+	Version     string `json:"version"`
+	DownloadURL string `json:"downloadUrl"`
+	SHA1        string `json:"sha1"`
+}
+
+func libericaRelease(ctx *gcp.Context, feature, imageType string) (*release, error) {
+	url := fmt.Sprintf(libericaURL, feature, imageType)
+	var releases []libericaJSON
+	if err := json.Unmarshal([]byte(fetch(ctx, url)), &releases); err != nil {
+		return nil, fmt.Errorf("parsing JSON returned by %s: %w", url, err)
+	}
+	if len(releases) == 0 {
+		return nil, nil
+	}
+	r := releases[0]
+	return &release{version: r.Version, url: r.DownloadURL, algorithm: sha1Algorithm, checksum: r.SHA1}, nil
+}
+
+// fetch returns the body of the response to a GET request.
+func fetch(ctx *gcp.Context, url string) string {
+	return ctx.Exec([]string{"curl", "--fail", "--show-error", "--silent", "--location", url}, gcp.WithUserAttribution).Stdout
+}
+
+// localReleases returns the JDK and the JRE, if any, of the feature version among the tarballs in a
+// directory. The tarballs are identified by the JAVA_VERSION in their release file, and are JDKs if
+// they include javac; if several match, the highest version is used. A tarball is verified against
+// the SHA-256 checksum in a file of the same name with a .sha256 suffix, if there is one.
+func localReleases(ctx *gcp.Context, dir, feature string) (*release, *release, error) {
+	var jdk, jre *release
+	archives := append(ctx.Glob(filepath.Join(dir, "*.tar.gz")), ctx.Glob(filepath.Join(dir, "*.tgz"))...)
+	for _, archive := range archives {
+		listing, lerr := ctx.ExecWithErr([]string{"tar", "--list", "--gzip", "--file", archive})
+		if lerr != nil {
+			ctx.Warnf("Skipping %s, listing it failed: %v", archive, lerr)
+			continue
+		}
+		m := releaseEntryRegexp.FindStringSubmatch(listing.Stdout)
+		if m == nil {
+			ctx.Debugf("Skipping %s, it has no release file.", archive)
+			continue
+		}
+		content := ctx.Exec([]string{"tar", "--extract", "--gzip", "--to-stdout", "--file", archive, m[1]}).Stdout
+		version := releaseVersion(content)
+		if featureVersion(version) != feature {
+			continue
+		}
+
+		checksum, err := fileChecksum(archive, sha256Algorithm)
+		if err != nil {
+			return nil, nil, gcp.InternalErrorf("computing checksum of %s: %v", archive, err)
+		}
+		if ctx.FileExists(archive + ".sha256") {
+			fields := strings.Fields(string(ctx.ReadFile(archive + ".sha256")))
+			if len(fields) == 0 || !strings.EqualFold(fields[0], checksum) {
+				return nil, nil, gcp.UserErrorf("checksum of %s does not match %s.sha256", archive, filepath.Base(archive))
+			}
+		}
+		r := &release{version: version, path: archive, algorithm: sha256Algorithm, checksum: checksum}
+		if javacEntryRegexp.MatchString(listing.Stdout) {
+			if jdk == nil || compareVersions(version, jdk.version) > 0 {
+				jdk = r
+			}
+		} else if jre == nil || compareVersions(version, jre.version) > 0 {
+			jre = r
+		}
+	}
+	return jdk, jre, nil
+}
+
+// releaseVersion returns the JAVA_VERSION in the contents of the release file of a JDK or JRE.
+func releaseVersion(content string) string {
+	if m := javaVersionRegexp.FindStringSubmatch(content); m != nil {
+		return m[1]
+	}
+	return ""
+}
+
+// featureVersion returns the feature version of a Java version, e.g. `11` for `11.0.17` and `8` for `1.8.0_352`.
+func featureVersion(version string) string {
+	version = strings.TrimPrefix(version, "1.")
+	if i := strings.IndexAny(version, ".+_-"); i >= 0 {
+		return version[:i]
+	}
+	return version
+}
+
+// compareVersions compares the numeric components of two Java versions, returning a positive number
+// if a is higher than b, a negative number if it is lower, and 0 if they are equal.
+func compareVersions(a, b string) int {
+	split := func(v string) []string {
+		return strings.FieldsFunc(v, func(r rune) bool { return r < '0' || r > '9' })
+	}
+	as, bs := split(a), split(b)
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		x, _ := strconv.Atoi(as[i])
+		y, _ := strconv.Atoi(bs[i])
+		if x != y {
+			return x - y
+		}
+	}
+	return len(as) - len(bs)
+}
+
+// fileChecksum returns the hex digest of a file with the given algorithm.
+func fileChecksum(path, algorithm string) (string, error) {
+	var h hash.Hash
+	switch algorithm {
+	case sha256Algorithm:
+		h = sha256.New()
+	case sha1Algorithm:
+		h = sha1.New()
+	default:
+		return "", fmt.Errorf("unsupported checksum algorithm %q", algorithm)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// verifyChecksum returns an error if the digest of a file does not match the published checksum.
+func verifyChecksum(path, algorithm, want string) error {
+	if want == "" {
+		return fmt.Errorf("no %s checksum published for %s", algorithm, path)
+	}
+	got, err := fileChecksum(path, algorithm)
+	if err != nil {
+		return err
+	}
+	if !strings.EqualFold(got, want) {
+		return fmt.Errorf("%s checksum %s does not match published checksum %s", algorithm, got, want)
+	}
+	return nil
+}
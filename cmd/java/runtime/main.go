@@ -13,27 +13,29 @@
 // limitations under the License.
 
 // Implements java/runtime buildpack.
-// The runtime buildpack installs the JDK.
+// The runtime buildpack installs the JDK, and a JRE to run the application if the distribution has one.
 package main
 
 import (
 	"encoding/json"
 	"fmt"
-	"net/http"
 	"os"
+	"path/filepath"
 	"strings"
 
 	"github.com/GoogleCloudPlatform/buildpacks/pkg/env"
 	gcp "github.com/GoogleCloudPlatform/buildpacks/pkg/gcpbuildpack"
+	"github.com/GoogleCloudPlatform/buildpacks/pkg/java"
 	"github.com/GoogleCloudPlatform/buildpacks/pkg/runtime"
 	"github.com/buildpacks/libcnb"
 )
 
 const (
 	javaLayer             = "java"
-	javaVersionURL        = "https://api.adoptopenjdk.net/v3/assets/feature_releases/%s/ga?architecture=x64&heap_size=normal&image_type=jdk&jvm_impl=hotspot&os=linux&page=0&page_size=1&project=jdk&sort_order=DESC&vendor=adoptopenjdk"
+	jreLayer              = "jre"
 	defaultFeatureVersion = "11"
 	versionKey            = "version"
+	checksumKey           = "checksum"
 )
 
 func main() {
@@ -72,52 +74,118 @@ func buildFn(ctx *gcp.Context) error {
 	if v := os.Getenv(env.RuntimeVersion); v != "" {
 		featureVersion = v
 		ctx.Logf("Using requested runtime feature version: %s", featureVersion)
+	} else if v, source := declaredFeatureVersion(ctx); v != "" {
+		featureVersion = v
+		ctx.Logf("Using Java feature version %s from %s. You can specify a different version with %s.", featureVersion, source, env.RuntimeVersion)
 	} else {
 		ctx.Logf("Using latest Java %s runtime version. You can specify a different version with %s: https://github.com/GoogleCloudPlatform/buildpacks#configuration", defaultFeatureVersion, env.RuntimeVersion)
 	}
-
-	releaseURL := fmt.Sprintf(javaVersionURL, featureVersion)
-	if code := ctx.HTTPStatus(releaseURL); code != http.StatusOK {
-		return gcp.UserErrorf("Java feature version %s does not exist at %s (status %d). You can specify the feature version with %s. See available feature runtime versions at https://api.adoptopenjdk.net/v3/info/available_releases", featureVersion, releaseURL, code, env.RuntimeVersion)
+	// In dev mode the application is rebuilt at run time, which requires the JDK.
+	devMode, err := env.IsDevMode()
+	if err != nil {
+		return gcp.UserErrorf("failed to parse %s: %v", env.DevMode, err)
 	}
 
-	result := ctx.Exec([]string{"curl", "--fail", "--show-error", "--silent", "--location", releaseURL}, gcp.WithUserAttribution)
-	release, err := parseVersionJSON(result.Stdout)
-	if err != nil {
-		return fmt.Errorf("parsing JSON returned by %s: %w", releaseURL, err)
+	var jdk, jre *release
+	if dir := os.Getenv(env.JavaJDKDir); dir != "" {
+		ctx.Logf("Using Java runtimes from %s.", dir)
+		if jdk, jre, err = localReleases(ctx, dir, featureVersion); err != nil {
+			return err
+		}
+		if jdk == nil {
+			return gcp.UserErrorf("no JDK tarball for Java feature version %s found in %s (%s). You can specify the feature version with %s", featureVersion, dir, env.JavaJDKDir, env.RuntimeVersion)
+		}
+	} else {
+		distribution := strings.ToLower(os.Getenv(env.JavaDistribution))
+		if distribution == "" {
+			distribution = defaultDistribution
+		}
+		resolve, ok := resolvers[distribution]
+		if !ok {
+			return gcp.UserErrorf("unsupported Java distribution %q in %s, supported distributions are: %s", distribution, env.JavaDistribution, strings.Join(distributions(), ", "))
+		}
+		ctx.Logf("Using the %s distribution of Java.", distribution)
+		if jdk, err = resolve(ctx, featureVersion, "jdk"); err != nil {
+			return err
+		}
+		if jdk == nil {
+			return gcp.UserErrorf("Java feature version %s is not available from the %s distribution. You can specify the feature version with %s and the distribution with %s", featureVersion, distribution, env.RuntimeVersion, env.JavaDistribution)
+		}
+		if !devMode {
+			if jre, err = resolve(ctx, featureVersion, "jre"); err != nil {
+				return err
+			}
+		}
+	}
+	// The application runs on the JRE only if it is the version of the JDK that builds it, e.g. not when
+	// a distribution has yet to publish the JRE of its latest release.
+	if jre != nil && (jre.version == "" || compareVersions(jre.version, jdk.version) != 0) {
+		ctx.Warnf("Java JRE v%s does not match JDK v%s, running the application on the JDK.", jre.version, jdk.version)
+		jre = nil
 	}
 
-	version, archiveURL, err := extractRelease(release)
-	if err != nil {
-		return fmt.Errorf("extracting release returned by %s: %w", releaseURL, err)
+	// The JDK is used to build the application, and to run it if there is no JRE.
+	l := ctx.Layer(javaLayer, gcp.BuildLayer, gcp.CacheLayer)
+	l.BuildEnvironment.Override("JAVA_HOME", l.Path)
+	if jre == nil || devMode {
+		l.Launch = true
+		l.LaunchEnvironment.Override("JAVA_HOME", l.Path)
+		l.BuildEnvironment.Override(java.LaunchHomeEnv, l.Path)
+	}
+	if err := installRelease(ctx, l, jdk); err != nil {
+		return err
+	}
+	if jre == nil || devMode {
+		return nil
 	}
 
-	// Check the metadata in the cache layer to determine if we need to proceed.
-	l := ctx.Layer(javaLayer, gcp.BuildLayer, gcp.CacheLayer, gcp.LaunchLayer)
-	metaVersion := ctx.GetMetadata(l, versionKey)
-	if version == metaVersion {
-		ctx.CacheHit(javaLayer)
+	jl := ctx.Layer(jreLayer, gcp.CacheLayer, gcp.LaunchLayer)
+	l.BuildEnvironment.Override(java.LaunchHomeEnv, jl.Path)
+	jl.LaunchEnvironment.Override("JAVA_HOME", jl.Path)
+	return installRelease(ctx, jl, jre)
+}
+
+// installRelease installs the JDK or JRE release in the layer, unless the layer already holds it.
+// Downloaded archives are verified against the published checksum.
+func installRelease(ctx *gcp.Context, l *libcnb.Layer, r *release) error {
+	checksum := r.algorithm + ":" + r.checksum
+	if checksum == ctx.GetMetadata(l, checksumKey) {
+		ctx.CacheHit(l.Name)
 		return nil
 	}
-	ctx.CacheMiss(javaLayer)
+	ctx.CacheMiss(l.Name)
 	ctx.ClearLayer(l)
 
-	// Download and install Java in layer.
-	ctx.Logf("Installing Java v%s", version)
+	archive := r.path
+	if archive == "" {
+		tmp := ctx.TempDir("", l.Name)
+		defer ctx.RemoveAll(tmp)
+		archive = filepath.Join(tmp, "java.tar.gz")
+		ctx.Exec([]string{"curl", "--fail", "--show-error", "--silent", "--location", "--retry", "3", "--output", archive, r.url}, gcp.WithUserAttribution)
+		if err := verifyChecksum(archive, r.algorithm, r.checksum); err != nil {
+			return gcp.InternalErrorf("verifying %s: %v", r.url, err)
+		}
+	}
+	ctx.Exec([]string{"tar", "xzf", archive, "--directory", l.Path, "--strip-components=1"}, gcp.WithUserAttribution)
 
-	command := fmt.Sprintf("curl --fail --show-error --silent --location --retry 3 %s | tar xz --directory %s --strip-components=1", archiveURL, l.Path)
-	ctx.Exec([]string{"bash", "-c", command}, gcp.WithUserAttribution)
+	version := r.version
+	if version == "" {
+		version = releaseVersion(string(ctx.ReadFile(filepath.Join(l.Path, "release"))))
+	}
+	ctx.Logf("Installed Java %s v%s", l.Name, version)
 
 	ctx.SetMetadata(l, versionKey, version)
+	ctx.SetMetadata(l, checksumKey, checksum)
 	ctx.AddBOMEntry(libcnb.BOMEntry{
-		Name:     javaLayer,
+		Name:     l.Name,
 		Metadata: map[string]interface{}{"version": version},
 	})
 	return nil
 }
 
 type binaryPkg struct {
-	Link string `json:"link"`
+	Link     string `json:"link"`
+	Checksum string `json:"checksum"`
 }
 
 type binary struct {
@@ -148,17 +216,18 @@ func parseVersionJSON(jsonStr string) (javaRelease, error) {
 	return releases[0], nil
 }
 
-// extractRelease returns the version name and archiveURL from a javaRelease.
-func extractRelease(release javaRelease) (string, string, error) {
+// extractRelease returns the version name and the package of the linux/x64 binary of the image type,
+// "jdk" or "jre", from a javaRelease.
+func extractRelease(release javaRelease, imageType string) (string, binaryPkg, error) {
 	if len(release.Binaries) == 0 {
-		return "", "", fmt.Errorf("no binaries in given release %s", release.VersionData.Semver)
+		return "", binaryPkg{}, fmt.Errorf("no binaries in given release %s", release.VersionData.Semver)
 	}
 
 	for _, binary := range release.Binaries {
-		if binary.ImageType == "jdk" && binary.OS == "linux" && binary.Architecture == "x64" {
-			return release.VersionData.Semver, binary.BinaryPkg.Link, nil
+		if binary.ImageType == imageType && binary.OS == "linux" && binary.Architecture == "x64" {
+			return release.VersionData.Semver, binary.BinaryPkg, nil
 		}
 	}
 
-	return "", "", fmt.Errorf("jdk/linux/x64 binary not found in release %s", release.VersionData.Semver)
+	return "", binaryPkg{}, fmt.Errorf("%s/linux/x64 binary not found in release %s", imageType, release.VersionData.Semver)
 }
@@ -15,10 +15,14 @@
 package main
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
 
 	gcp "github.com/GoogleCloudPlatform/buildpacks/pkg/gcpbuildpack"
+	"github.com/buildpacks/libcnb"
 )
 
 func TestDetect(t *testing.T) {
@@ -279,15 +283,15 @@ func TestExtractRelease(t *testing.T) {
 	}
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			gotVersion, gotBinaryLink, err := extractRelease(tc.javaRelease)
+			gotVersion, gotBinaryPkg, err := extractRelease(tc.javaRelease, "jdk")
 			if err != nil {
 				t.Fatalf("extractRelease() returned error: %v", err)
 			}
 			if gotVersion != tc.wantVersion {
 				t.Errorf("release version from extractRelease()=%s, want=%s", gotVersion, tc.wantVersion)
 			}
-			if gotBinaryLink != tc.wantBinaryLink {
-				t.Errorf("binaries from extractRelease()=%v, want=%v", gotBinaryLink, tc.wantBinaryLink)
+			if gotBinaryPkg.Link != tc.wantBinaryLink {
+				t.Errorf("binaries from extractRelease()=%v, want=%v", gotBinaryPkg.Link, tc.wantBinaryLink)
 			}
 		})
 	}
@@ -328,10 +332,336 @@ func TestExtractReleaseFail(t *testing.T) {
 	}
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			_, _, err := extractRelease(tc.javaRelease)
+			_, _, err := extractRelease(tc.javaRelease, "jdk")
 			if err == nil {
 				t.Error("extractRelease() did not return error.")
 			}
 		})
 	}
 }
+
+func TestFeatureVersion(t *testing.T) {
+	testCases := []struct {
+		version string
+		want    string
+	}{
+		{version: "11.0.17", want: "11"},
+		{version: "17.0.5+8", want: "17"},
+		{version: "1.8.0_352", want: "8"},
+		{version: "19", want: "19"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.version, func(t *testing.T) {
+			if got := featureVersion(tc.version); got != tc.want {
+				t.Errorf("featureVersion(%q) = %q, want %q", tc.version, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	testCases := []struct {
+		a, b string
+		want int
+	}{
+		{a: "11.0.17", b: "11.0.9", want: 1},
+		{a: "11.0.9", b: "11.0.17", want: -1},
+		{a: "17.0.5+8", b: "17.0.5+8", want: 0},
+		{a: "17.0.5.1", b: "17.0.5", want: 1},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.a+" vs "+tc.b, func(t *testing.T) {
+			got := compareVersions(tc.a, tc.b)
+			if (got > 0) != (tc.want > 0) || (got < 0) != (tc.want < 0) {
+				t.Errorf("compareVersions(%q, %q) = %d, want sign of %d", tc.a, tc.b, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestReleaseVersion(t *testing.T) {
+	content := `IMPLEMENTOR="Amazon.com Inc."
+JAVA_VERSION="11.0.17"
+JAVA_VERSION_DATE="2022-10-18"
+`
+	if got, want := releaseVersion(content), "11.0.17"; got != want {
+		t.Errorf("releaseVersion() = %q, want %q", got, want)
+	}
+	if got := releaseVersion("IMPLEMENTOR=\"Azul Systems, Inc.\"\n"); got != "" {
+		t.Errorf("releaseVersion() without JAVA_VERSION = %q, want empty", got)
+	}
+}
+
+func TestVerifyChecksum(t *testing.T) {
+	f, err := ioutil.TempFile("", "test-verify-checksum-")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.WriteString("hello\n"); err != nil {
+		t.Fatalf("Failed to write temp file: %v", err)
+	}
+	f.Close()
+
+	testCases := []struct {
+		name      string
+		algorithm string
+		checksum  string
+		wantErr   bool
+	}{
+		{
+			name:      "sha256",
+			algorithm: sha256Algorithm,
+			checksum:  "5891b5b522d5df086d0ff0b110fbd9d21bb4fc7163af34d08286a2e846f6be03",
+		},
+		{
+			name:      "sha256 upper case",
+			algorithm: sha256Algorithm,
+			checksum:  "5891B5B522D5DF086D0FF0B110FBD9D21BB4FC7163AF34D08286A2E846F6BE03",
+		},
+		{
+			name:      "sha1",
+			algorithm: sha1Algorithm,
+			checksum:  "f572d396fae9206628714fb2ce00f72e94f2258f",
+		},
+		{
+			name:      "mismatch",
+			algorithm: sha256Algorithm,
+			checksum:  "0000000000000000000000000000000000000000000000000000000000000000",
+			wantErr:   true,
+		},
+		{
+			name:      "not published",
+			algorithm: sha256Algorithm,
+			wantErr:   true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := verifyChecksum(f.Name(), tc.algorithm, tc.checksum)
+			if gotErr := err != nil; gotErr != tc.wantErr {
+				t.Errorf("verifyChecksum() got error: %v, want error: %t", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestPomVersion(t *testing.T) {
+	testCases := []struct {
+		name        string
+		pom         string
+		wantVersion string
+		wantSetting string
+	}{
+		{
+			name: "release property",
+			pom: `<project>
+  <properties>
+    <maven.compiler.source>1.8</maven.compiler.source>
+    <maven.compiler.release>17</maven.compiler.release>
+  </properties>
+</project>`,
+			wantVersion: "17",
+			wantSetting: "maven.compiler.release",
+		},
+		{
+			name: "source property referencing another property",
+			pom: `<project>
+  <properties>
+    <java.version>1.8</java.version>
+    <maven.compiler.source>${java.version}</maven.compiler.source>
+  </properties>
+</project>`,
+			wantVersion: "1.8",
+			wantSetting: "maven.compiler.source",
+		},
+		{
+			name: "compiler plugin release",
+			pom: `<project>
+  <properties>
+    <java.version>11</java.version>
+  </properties>
+  <build>
+    <plugins>
+      <plugin>
+        <artifactId>maven-jar-plugin</artifactId>
+      </plugin>
+      <plugin>
+        <artifactId>maven-compiler-plugin</artifactId>
+        <configuration>
+          <release>${java.version}</release>
+        </configuration>
+      </plugin>
+    </plugins>
+  </build>
+</project>`,
+			wantVersion: "11",
+			wantSetting: "maven-compiler-plugin release",
+		},
+		{
+			name: "compiler plugin target",
+			pom: `<project>
+  <build>
+    <plugins>
+      <plugin>
+        <artifactId>maven-compiler-plugin</artifactId>
+        <configuration>
+          <target>17</target>
+        </configuration>
+      </plugin>
+    </plugins>
+  </build>
+</project>`,
+			wantVersion: "17",
+			wantSetting: "maven-compiler-plugin target",
+		},
+		{
+			name:        "no version",
+			pom:         `<project><artifactId>app</artifactId></project>`,
+			wantVersion: "",
+			wantSetting: "",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			gotVersion, gotSetting, err := pomVersion([]byte(tc.pom))
+			if err != nil {
+				t.Fatalf("pomVersion() got error: %v", err)
+			}
+			if gotVersion != tc.wantVersion || gotSetting != tc.wantSetting {
+				t.Errorf("pomVersion() = (%q, %q), want (%q, %q)", gotVersion, gotSetting, tc.wantVersion, tc.wantSetting)
+			}
+		})
+	}
+}
+
+func TestGradleVersion(t *testing.T) {
+	testCases := []struct {
+		name        string
+		build       string
+		wantVersion string
+		wantSetting string
+	}{
+		{
+			name:        "groovy toolchain",
+			build:       "java {\n    toolchain {\n        languageVersion = JavaLanguageVersion.of(17)\n    }\n}\nsourceCompatibility = '11'\n",
+			wantVersion: "17",
+			wantSetting: "java.toolchain.languageVersion",
+		},
+		{
+			name:        "kotlin toolchain",
+			build:       "java {\n    toolchain {\n        languageVersion.set(JavaLanguageVersion.of(\"11\"))\n    }\n}\n",
+			wantVersion: "11",
+			wantSetting: "java.toolchain.languageVersion",
+		},
+		{
+			name:        "source compatibility",
+			build:       "sourceCompatibility = 1.8\n",
+			wantVersion: "1.8",
+			wantSetting: "sourceCompatibility",
+		},
+		{
+			name:        "source compatibility constant",
+			build:       "java {\n    sourceCompatibility = JavaVersion.VERSION_1_8\n}\n",
+			wantVersion: "1.8",
+			wantSetting: "sourceCompatibility",
+		},
+		{
+			name:  "no version",
+			build: "plugins {\n    id 'java'\n}\n",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			gotVersion, gotSetting := gradleVersion(tc.build)
+			if gotVersion != tc.wantVersion || gotSetting != tc.wantSetting {
+				t.Errorf("gradleVersion() = (%q, %q), want (%q, %q)", gotVersion, gotSetting, tc.wantVersion, tc.wantSetting)
+			}
+		})
+	}
+}
+
+func TestDeclaredFeatureVersion(t *testing.T) {
+	testCases := []struct {
+		name        string
+		files       map[string]string
+		wantVersion string
+		wantSource  string
+	}{
+		{
+			name: "java-version takes precedence",
+			files: map[string]string{
+				".java-version": "temurin-17.0.5\n",
+				"pom.xml":       "<project><properties><maven.compiler.release>11</maven.compiler.release></properties></project>",
+			},
+			wantVersion: "17",
+			wantSource:  ".java-version",
+		},
+		{
+			name: "sdkmanrc",
+			files: map[string]string{
+				".sdkmanrc": "# Java version\njava=11.0.17-tem\n",
+			},
+			wantVersion: "11",
+			wantSource:  ".sdkmanrc",
+		},
+		{
+			name: "pom.xml source raised to the default",
+			files: map[string]string{
+				"pom.xml": "<project><properties><maven.compiler.source>1.8</maven.compiler.source></properties></project>",
+			},
+			wantVersion: "11",
+			wantSource:  "pom.xml (maven.compiler.source)",
+		},
+		{
+			name: "pom.xml release below the default",
+			files: map[string]string{
+				"pom.xml": "<project><properties><maven.compiler.release>8</maven.compiler.release></properties></project>",
+			},
+			wantVersion: "8",
+			wantSource:  "pom.xml (maven.compiler.release)",
+		},
+		{
+			name: "build.gradle source compatibility above the default",
+			files: map[string]string{
+				"build.gradle": "sourceCompatibility = JavaVersion.VERSION_17\n",
+			},
+			wantVersion: "17",
+			wantSource:  "build.gradle (sourceCompatibility)",
+		},
+		{
+			name: "build.gradle.kts",
+			files: map[string]string{
+				"build.gradle.kts": "java {\n    toolchain {\n        languageVersion.set(JavaLanguageVersion.of(17))\n    }\n}\n",
+			},
+			wantVersion: "17",
+			wantSource:  "build.gradle.kts (java.toolchain.languageVersion)",
+		},
+		{
+			name: "no declared version",
+			files: map[string]string{
+				"build.gradle": "plugins {\n    id 'java'\n}\n",
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "declared-feature-version-")
+			if err != nil {
+				t.Fatalf("Failed to create temp dir: %v", err)
+			}
+			defer os.RemoveAll(dir)
+			for f, content := range tc.files {
+				if err := ioutil.WriteFile(filepath.Join(dir, f), []byte(content), 0644); err != nil {
+					t.Fatalf("Failed to write %s: %v", f, err)
+				}
+			}
+			ctx := gcp.NewContextForTests(libcnb.BuildpackInfo{}, dir)
+
+			gotVersion, gotSource := declaredFeatureVersion(ctx)
+			if gotVersion != tc.wantVersion || gotSource != tc.wantSource {
+				t.Errorf("declaredFeatureVersion() = (%q, %q), want (%q, %q)", gotVersion, gotSource, tc.wantVersion, tc.wantSource)
+			}
+		})
+	}
+}
@@ -0,0 +1,172 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/buildpacks/pkg/env"
+	gcp "github.com/GoogleCloudPlatform/buildpacks/pkg/gcpbuildpack"
+)
+
+var (
+	// versionRegexp matches a Java version in a version file, e.g. `17.0.5` in `temurin-17.0.5`.
+	versionRegexp = regexp.MustCompile(`\d+(\.\d+)*`)
+	// sdkmanRegexp matches the Java candidate of an .sdkmanrc file, e.g. `java=17.0.5-tem`.
+	sdkmanRegexp = regexp.MustCompile(`(?m)^\s*java\s*=\s*(\d+(\.\d+)*)`)
+	// toolchainRegexp matches the language version of a Gradle Java toolchain, e.g.
+	// `languageVersion = JavaLanguageVersion.of(17)` or `languageVersion.set(JavaLanguageVersion.of("17"))`.
+	toolchainRegexp = regexp.MustCompile(`languageVersion\s*(?:=|\.set\()\s*JavaLanguageVersion\.of\(\s*["']?(\d+)["']?\s*\)`)
+	// sourceCompatibilityRegexp matches the source compatibility of a Gradle build, e.g.
+	// `sourceCompatibility = '11'`, `sourceCompatibility = 1.8` or `sourceCompatibility = JavaVersion.VERSION_17`.
+	sourceCompatibilityRegexp = regexp.MustCompile(`sourceCompatibility\s*=\s*(?:JavaVersion\.VERSION_(\d+(?:_\d+)?)|["']?(\d+(?:\.\d+)?)["']?)`)
+	// propertyRegexp matches a reference to a Maven property, e.g. `${java.version}`.
+	propertyRegexp = regexp.MustCompile(`^\$\{([^}]+)\}$`)
+
+	// minimumSettings declare the lowest Java version that the application compiles for, which newer
+	// JDKs also compile for, rather than the version that it requires.
+	minimumSettings = map[string]bool{
+		"maven.compiler.source":        true,
+		"maven.compiler.target":        true,
+		"maven-compiler-plugin source": true,
+		"maven-compiler-plugin target": true,
+		"sourceCompatibility":          true,
+	}
+)
+
+// pomXML holds the parts of a pom.xml file that declare the Java version.
+type pomXML struct {
+	Properties struct {
+		Entries []struct {
+			XMLName xml.Name
+			Value   string `xml:",chardata"`
+		} `xml:",any"`
+	} `xml:"properties"`
+	Plugins []struct {
+		ArtifactID    string `xml:"artifactId"`
+		Configuration struct {
+			Release string `xml:"release"`
+			Source  string `xml:"source"`
+			Target  string `xml:"target"`
+		} `xml:"configuration"`
+	} `xml:"build>plugins>plugin"`
+}
+
+// declaredFeatureVersion returns the Java feature version that the application declares and where it
+// is declared, or empty strings if it declares none. Version files take precedence over the versions
+// that pom.xml and the Gradle build files compile for. Source and target levels are a minimum, so those
+// below defaultFeatureVersion are raised to it.
+func declaredFeatureVersion(ctx *gcp.Context) (string, string) {
+	root := ctx.ApplicationRoot()
+	if ctx.FileExists(root, ".java-version") {
+		if v := versionRegexp.FindString(string(ctx.ReadFile(filepath.Join(root, ".java-version")))); v != "" {
+			return featureVersion(v), ".java-version"
+		}
+		ctx.Warnf("Ignoring .java-version, it does not contain a Java version.")
+	}
+	if ctx.FileExists(root, ".sdkmanrc") {
+		if m := sdkmanRegexp.FindStringSubmatch(string(ctx.ReadFile(filepath.Join(root, ".sdkmanrc")))); m != nil {
+			return featureVersion(m[1]), ".sdkmanrc"
+		}
+	}
+	if ctx.FileExists(root, "pom.xml") {
+		v, property, err := pomVersion(ctx.ReadFile(filepath.Join(root, "pom.xml")))
+		if err != nil {
+			ctx.Warnf("Ignoring the Java version in pom.xml, parsing it failed: %v", err)
+		} else if v != "" {
+			return minimumFeatureVersion(ctx, featureVersion(v), property), fmt.Sprintf("pom.xml (%s)", property)
+		}
+	}
+	for _, f := range []string{"build.gradle", "build.gradle.kts"} {
+		if !ctx.FileExists(root, f) {
+			continue
+		}
+		if v, setting := gradleVersion(string(ctx.ReadFile(filepath.Join(root, f)))); v != "" {
+			return minimumFeatureVersion(ctx, featureVersion(v), setting), fmt.Sprintf("%s (%s)", f, setting)
+		}
+	}
+	return "", ""
+}
+
+// minimumFeatureVersion returns the feature version declared by the given setting, raised to
+// defaultFeatureVersion if the setting is a minimum below it.
+func minimumFeatureVersion(ctx *gcp.Context, version, setting string) string {
+	if !minimumSettings[setting] || compareVersions(version, defaultFeatureVersion) >= 0 {
+		return version
+	}
+	ctx.Logf("Raising Java feature version %s of %s to %s, which also compiles for it. You can request an exact version with %s.", version, setting, defaultFeatureVersion, env.RuntimeVersion)
+	return defaultFeatureVersion
+}
+
+// pomVersion returns the Java version that a pom.xml file compiles for and the setting that declares
+// it. The release takes precedence over the source and then the target version, and properties take precedence over the
+// configuration of the compiler plugin. References to other properties are resolved.
+func pomVersion(content []byte) (string, string, error) {
+	var pom pomXML
+	if err := xml.Unmarshal(content, &pom); err != nil {
+		return "", "", err
+	}
+	props := map[string]string{}
+	for _, p := range pom.Properties.Entries {
+		props[p.XMLName.Local] = strings.TrimSpace(p.Value)
+	}
+	resolve := func(v string) string {
+		if m := propertyRegexp.FindStringSubmatch(strings.TrimSpace(v)); m != nil {
+			return props[m[1]]
+		}
+		return strings.TrimSpace(v)
+	}
+
+	var release, source, target string
+	for _, p := range pom.Plugins {
+		if p.ArtifactID == "maven-compiler-plugin" {
+			release, source, target = resolve(p.Configuration.Release), resolve(p.Configuration.Source), resolve(p.Configuration.Target)
+		}
+	}
+	candidates := []struct{ setting, value string }{
+		{"maven.compiler.release", resolve(props["maven.compiler.release"])},
+		{"maven-compiler-plugin release", release},
+		{"maven.compiler.source", resolve(props["maven.compiler.source"])},
+		{"maven-compiler-plugin source", source},
+		{"maven.compiler.target", resolve(props["maven.compiler.target"])},
+		{"maven-compiler-plugin target", target},
+	}
+	for _, c := range candidates {
+		if versionRegexp.MatchString(c.value) {
+			return c.value, c.setting, nil
+		}
+	}
+	return "", "", nil
+}
+
+// gradleVersion returns the Java version that a Groovy or Kotlin Gradle build file compiles for and
+// the setting that declares it. The toolchain language version takes precedence over the source
+// compatibility.
+func gradleVersion(content string) (string, string) {
+	if m := toolchainRegexp.FindStringSubmatch(content); m != nil {
+		return m[1], "java.toolchain.languageVersion"
+	}
+	if m := sourceCompatibilityRegexp.FindStringSubmatch(content); m != nil {
+		if m[1] != "" {
+			return strings.ReplaceAll(m[1], "_", "."), "sourceCompatibility"
+		}
+		return m[2], "sourceCompatibility"
+	}
+	return "", ""
+}
@@ -0,0 +1,45 @@
+#!/bin/bash
+# Copyright 2020 Google LLC
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+
+# Node.js launcher that sizes the V8 heap based on the container memory limit.
+# If $NODE_OPTIONS already contains --max-old-space-size, we just launch the
+# command we are given. Otherwise, if the cgroup (v2 or v1) of the container has
+# a memory limit, we append --max-old-space-size to NODE_OPTIONS so that the old
+# space uses $NODE_HEAP_PERCENTAGE percent (default 75) of that limit. Without a
+# limit, V8 picks its own default heap size.
+
+limit=""
+if [[ -r /sys/fs/cgroup/memory.max ]]; then
+  limit="$(cat /sys/fs/cgroup/memory.max)"
+elif [[ -r /sys/fs/cgroup/memory/memory.limit_in_bytes ]]; then
+  limit="$(cat /sys/fs/cgroup/memory/memory.limit_in_bytes)"
+fi
+
+if [[ "${NODE_OPTIONS}" != *--max-old-space-size* ]]
+then
+  percentage="${NODE_HEAP_PERCENTAGE:-75}"
+  if ! [[ "${percentage}" =~ ^[0-9]+$ ]] || (( percentage < 1 || percentage > 100 )); then
+    echo "Ignoring invalid NODE_HEAP_PERCENTAGE=${percentage}, using 75." >&2
+    percentage=75
+  fi
+
+  # cgroup v2 reports "max" without a limit; cgroup v1 reports a value close to
+  # the largest 64-bit integer, so treat anything above 1 TiB as unlimited.
+  if [[ "${limit}" =~ ^[0-9]+$ ]] && (( limit < 1 << 40 )); then
+    heap=$(( limit / 1024 / 1024 * percentage / 100 ))
+    export NODE_OPTIONS="${NODE_OPTIONS:+${NODE_OPTIONS} }--max-old-space-size=${heap}"
+  fi
+fi
+exec "$@"
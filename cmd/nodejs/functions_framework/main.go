@@ -17,6 +17,7 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -25,12 +26,19 @@ import (
 	"github.com/GoogleCloudPlatform/buildpacks/pkg/env"
 	gcp "github.com/GoogleCloudPlatform/buildpacks/pkg/gcpbuildpack"
 	"github.com/GoogleCloudPlatform/buildpacks/pkg/nodejs"
+	"github.com/blang/semver"
 	"github.com/buildpacks/libcnb"
 )
 
 const (
 	layerName                 = "functions-framework"
+	bundleLayerName           = "bundle"
+	launcherLayerName         = "launcher"
 	functionsFrameworkPackage = "@openfunction/functions-framework"
+	// frameworkVersionKey is the layer metadata key of the version requested in FUNC_FRAMEWORK_VERSION.
+	frameworkVersionKey = "framework_version"
+	// minFrameworkVersion is the oldest functions-framework version supported by this builder.
+	minFrameworkVersion = "0.5.0"
 )
 
 func main() {
@@ -96,6 +104,10 @@ func buildFn(ctx *gcp.Context) error {
 	if hasFrameworkDependency {
 		ctx.Logf("Handling functions with dependency on functions-framework.")
 		ctx.ClearLayer(l)
+		if version := os.Getenv(env.FunctionsFrameworkVersion); version != "" {
+			ctx.Warnf("Ignoring %s=%s, using the %s version from package.json.", env.FunctionsFrameworkVersion, version, functionsFrameworkPackage)
+		}
+		checkFrameworkVersion(ctx)
 		ff = filepath.Join("node_modules", ff)
 	} else {
 		ctx.Logf("Handling functions without dependency on functions-framework.")
@@ -112,14 +124,41 @@ func buildFn(ctx *gcp.Context) error {
 		}
 	}
 
+	bundle, err := env.IsNodeJSBundle()
+	if err != nil {
+		return gcp.UserErrorf("failed to parse %s: %v", env.NodeJSBundle, err)
+	}
+	if bundle {
+		bl := ctx.Layer(bundleLayerName, gcp.LaunchLayer)
+		// The framework loads the function itself, so it is never part of the bundle.
+		if err := nodejs.BundleFunction(ctx, fnFile, bl, []string{functionsFrameworkPackage}); err != nil {
+			return fmt.Errorf("bundling function: %w", err)
+		}
+		// Packages kept external to the bundle are resolved from the user's node_modules.
+		if nm := filepath.Join(ctx.ApplicationRoot(), "node_modules"); ctx.FileExists(nm) {
+			l.LaunchEnvironment.Default("NODE_PATH", nm)
+		}
+		ff = fmt.Sprintf("%s --source %s", ff, bl.Path)
+	}
+
 	ctx.SetFunctionsEnvVars(l)
-	ctx.AddDefaultWebProcess([]string{"/bin/sh", "-c", ff}, true)
-  
+
+	// The launcher sizes the V8 heap to the container memory limit before starting the framework.
+	ll := ctx.Layer(launcherLayerName, gcp.LaunchLayer)
+	launcher := filepath.Join(ll.Path, "launch.sh")
+	ctx.WriteFile(launcher, ctx.ReadFile(filepath.Join(ctx.BuildpackRoot(), "launch.sh")), 0755)
+	ctx.AddDefaultWebProcess([]string{launcher, "/bin/sh", "-c", ff}, true)
+
 	return nil
 }
 
 // installFunctionsFramework downloads the functions-framework package to node_modules in the given layer.
+// The version pinned by the buildpack is installed unless another version is requested in FUNC_FRAMEWORK_VERSION.
 func installFunctionsFramework(ctx *gcp.Context, l *libcnb.Layer) error {
+	if version := os.Getenv(env.FunctionsFrameworkVersion); version != "" {
+		return installFunctionsFrameworkVersion(ctx, l, version)
+	}
+
 	cvt := filepath.Join(ctx.BuildpackRoot(), "converter", "without-framework")
 	pjs := filepath.Join(cvt, "package.json")
 	pljs := filepath.Join(cvt, nodejs.PackageLock)
@@ -135,8 +174,73 @@ func installFunctionsFramework(ctx *gcp.Context, l *libcnb.Layer) error {
 
 	ctx.CacheMiss(layerName)
 	ctx.ClearLayer(l)
+	// ClearLayer keeps the metadata, which must not name a requested version once the pinned one is installed.
+	delete(l.Metadata, frameworkVersionKey)
 	// NPM expects package.json and the lock file in the prefix directory.
 	ctx.Exec([]string{"cp", "-t", l.Path, pjs, pljs}, gcp.WithUserTimingAttribution)
 	ctx.Exec([]string{"npm", nodejs.NPMInstallCommand(ctx), "--quiet", "--production", "--prefix", l.Path}, gcp.WithUserAttribution)
 	return nil
 }
+
+// installFunctionsFrameworkVersion installs the given version of the functions-framework package to
+// node_modules in the given layer, from a package.json generated for that version.
+func installFunctionsFrameworkVersion(ctx *gcp.Context, l *libcnb.Layer, version string) error {
+	cached, err := nodejs.CheckCache(ctx, l, cache.WithStrings(nodejs.EnvProduction, functionsFrameworkPackage+"@"+version))
+	if err != nil {
+		return fmt.Errorf("checking cache: %w", err)
+	}
+	if cached {
+		ctx.CacheHit(layerName)
+		ctx.Logf("Using cached %s@%s.", functionsFrameworkPackage, ctx.GetMetadata(l, frameworkVersionKey))
+		return nil
+	}
+
+	ctx.CacheMiss(layerName)
+	ctx.ClearLayer(l)
+	pjs, err := json.MarshalIndent(map[string]interface{}{
+		"name":         "functions-framework",
+		"dependencies": map[string]string{functionsFrameworkPackage: version},
+		"scripts":      map[string]string{"start": "functions-framework"},
+	}, "", "  ")
+	if err != nil {
+		return gcp.InternalErrorf("marshalling package.json: %v", err)
+	}
+	ctx.WriteFile(filepath.Join(l.Path, "package.json"), pjs, 0644)
+	ctx.Logf("Installing %s@%s from %s.", functionsFrameworkPackage, version, env.FunctionsFrameworkVersion)
+	// npm generates the lock file next to package.json in the prefix directory.
+	ctx.Exec([]string{"npm", "install", "--quiet", "--production", "--prefix", l.Path}, gcp.WithUserAttribution)
+	ctx.SetMetadata(l, frameworkVersionKey, version)
+	return nil
+}
+
+// checkFrameworkVersion warns if the functions-framework installed from the user's dependencies is older
+// than the minimum version supported by the builder.
+func checkFrameworkVersion(ctx *gcp.Context) {
+	dir := filepath.Join(ctx.ApplicationRoot(), "node_modules", functionsFrameworkPackage)
+	if !ctx.FileExists(dir, "package.json") {
+		ctx.Debugf("%s is not installed in node_modules, skipping version check.", functionsFrameworkPackage)
+		return
+	}
+	pjs, err := nodejs.ReadPackageJSON(dir)
+	if err != nil {
+		ctx.Debugf("Skipping %s version check: %v", functionsFrameworkPackage, err)
+		return
+	}
+	older, err := olderThanMinimum(pjs.Version)
+	if err != nil {
+		ctx.Debugf("Skipping %s version check: %v", functionsFrameworkPackage, err)
+		return
+	}
+	if older {
+		ctx.Warnf("Your function depends on %s v%s, which is older than v%s, the minimum version supported by this builder. Update the dependency in package.json.", functionsFrameworkPackage, pjs.Version, minFrameworkVersion)
+	}
+}
+
+// olderThanMinimum returns true if the given functions-framework version is older than minFrameworkVersion.
+func olderThanMinimum(version string) (bool, error) {
+	v, err := semver.ParseTolerant(version)
+	if err != nil {
+		return false, fmt.Errorf("parsing version %q: %v", version, err)
+	}
+	return v.LT(semver.MustParse(minFrameworkVersion)), nil
+}
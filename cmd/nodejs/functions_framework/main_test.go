@@ -44,3 +44,31 @@ func TestDetect(t *testing.T) {
 		})
 	}
 }
+
+func TestOlderThanMinimum(t *testing.T) {
+	testCases := []struct {
+		version string
+		want    bool
+	}{
+		{version: "0.3.6", want: true},
+		{version: "0.4.9", want: true},
+		{version: "0.5.0", want: false},
+		{version: "0.6.0-beta.1", want: false},
+		{version: "1.0.0", want: false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.version, func(t *testing.T) {
+			got, err := olderThanMinimum(tc.version)
+			if err != nil {
+				t.Fatalf("olderThanMinimum(%q) got error: %v", tc.version, err)
+			}
+			if got != tc.want {
+				t.Errorf("olderThanMinimum(%q) = %t, want %t", tc.version, got, tc.want)
+			}
+		})
+	}
+
+	if _, err := olderThanMinimum("latest"); err == nil {
+		t.Error("olderThanMinimum(\"latest\") did not return error")
+	}
+}
@@ -42,13 +42,61 @@ func detectFn(ctx *gcp.Context) (gcp.DetectResult, error) {
 }
 
 func buildFn(ctx *gcp.Context) error {
+	pjs, err := nodejs.ReadPackageJSON(ctx.ApplicationRoot())
+	if err != nil {
+		return fmt.Errorf("reading package.json: %w", err)
+	}
+	if _, err := nodejs.ActivatePackageManager(ctx, pjs, "npm"); err != nil {
+		return fmt.Errorf("activating npm: %w", err)
+	}
+
+	nodeEnv := nodejs.NodeEnv()
+	if !nodejs.UsePrunedDependencies(ctx) {
+		if err := installDependencies(ctx, nodeEnv); err != nil {
+			return err
+		}
+	}
+
+	el := ctx.Layer("env", gcp.BuildLayer, gcp.LaunchLayer)
+	el.SharedEnvironment.Default("PATH", filepath.Join(ctx.ApplicationRoot(), "node_modules", ".bin"))
+	el.SharedEnvironment.Default("NODE_ENV", nodeEnv)
+
+	// Configure the entrypoint for production.
+	cmd := []string{"npm", "start"}
+
+	if !devmode.Enabled(ctx) {
+		ctx.AddWebProcess(cmd)
+		return nil
+	}
+
+	// Configure the entrypoint and metadata for dev mode.
+	devmode.AddFileWatcherProcess(ctx, devmode.Config{
+		RunCmd: cmd,
+		Ext:    devmode.NodeWatchedExtensions,
+	})
+	devmode.AddSyncMetadata(ctx, devmode.NodeSyncRules)
+
+	return nil
+}
+
+// installDependencies installs the dependencies for the given NODE_ENV into node_modules, restoring
+// them from the cache layer when package.json and the lockfile are unchanged.
+func installDependencies(ctx *gcp.Context, nodeEnv string) error {
 	ml := ctx.Layer("npm", gcp.BuildLayer, gcp.CacheLayer)
 	nm := filepath.Join(ml.Path, "node_modules")
 	ctx.RemoveAll("node_modules")
 
-	lockfile := nodejs.EnsureLockfile(ctx)
+	npmrc, removeNPMRC, err := nodejs.RegistryConfig(ctx)
+	if err != nil {
+		return fmt.Errorf("configuring npm registry: %w", err)
+	}
+	defer removeNPMRC()
+
+	lockfile, err := nodejs.EnsureLockfile(ctx, npmrc)
+	if err != nil {
+		return err
+	}
 
-	nodeEnv := nodejs.NodeEnv()
 	cached, err := nodejs.CheckCache(ctx, ml, cache.WithStrings(nodeEnv), cache.WithFiles("package.json", lockfile))
 	if err != nil {
 		return fmt.Errorf("checking cache: %w", err)
@@ -57,40 +105,21 @@ func buildFn(ctx *gcp.Context) error {
 		ctx.CacheHit(cacheTag)
 		// Restore cached node_modules.
 		ctx.Exec([]string{"cp", "--archive", nm, "node_modules"}, gcp.WithUserTimingAttribution)
+		nodejs.RebuildNativeAddons(ctx, ml, gcp.WithEnv("NODE_ENV="+nodeEnv), npmrc)
 
 		// Always run npm install to run preinstall/postinstall scripts.
 		// Otherwise it should be a no-op because the lockfile is unchanged.
-		ctx.Exec([]string{"npm", "install", "--quiet"}, gcp.WithEnv("NODE_ENV="+nodeEnv), gcp.WithUserAttribution)
+		ctx.Exec([]string{"npm", "install", "--quiet"}, gcp.WithEnv("NODE_ENV="+nodeEnv), nodejs.WithNativeAddonTips(ctx), gcp.WithUserAttribution, npmrc)
 	} else {
 		ctx.CacheMiss(cacheTag)
 		// Clear cached node_modules to ensure we don't end up with outdated dependencies after copying.
 		ctx.ClearLayer(ml)
 
-		ctx.Exec([]string{"npm", nodejs.NPMInstallCommand(ctx), "--quiet"}, gcp.WithEnv("NODE_ENV="+nodeEnv), gcp.WithUserAttribution)
+		ctx.Exec([]string{"npm", nodejs.NPMInstallCommand(ctx), "--quiet"}, gcp.WithEnv("NODE_ENV="+nodeEnv), nodejs.WithNativeAddonTips(ctx), gcp.WithUserAttribution, npmrc)
 
 		// Ensure node_modules exists even if no dependencies were installed.
 		ctx.MkdirAll("node_modules", 0755)
 		ctx.Exec([]string{"cp", "--archive", "node_modules", nm}, gcp.WithUserTimingAttribution)
 	}
-
-	el := ctx.Layer("env", gcp.BuildLayer, gcp.LaunchLayer)
-	el.SharedEnvironment.Default("PATH", filepath.Join(ctx.ApplicationRoot(), "node_modules", ".bin"))
-	el.SharedEnvironment.Default("NODE_ENV", nodeEnv)
-
-	// Configure the entrypoint for production.
-	cmd := []string{"npm", "start"}
-
-	if !devmode.Enabled(ctx) {
-		ctx.AddWebProcess(cmd)
-		return nil
-	}
-
-	// Configure the entrypoint and metadata for dev mode.
-	devmode.AddFileWatcherProcess(ctx, devmode.Config{
-		RunCmd: cmd,
-		Ext:    devmode.NodeWatchedExtensions,
-	})
-	devmode.AddSyncMetadata(ctx, devmode.NodeSyncRules)
-
 	return nil
 }
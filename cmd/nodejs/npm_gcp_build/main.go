@@ -50,11 +50,28 @@ func detectFn(ctx *gcp.Context) (gcp.DetectResult, error) {
 }
 
 func buildFn(ctx *gcp.Context) error {
+	pjs, err := nodejs.ReadPackageJSON(ctx.ApplicationRoot())
+	if err != nil {
+		return fmt.Errorf("reading package.json: %w", err)
+	}
+	if _, err := nodejs.ActivatePackageManager(ctx, pjs, "npm"); err != nil {
+		return fmt.Errorf("activating npm: %w", err)
+	}
+
 	l := ctx.Layer("npm", gcp.CacheLayer)
 	nm := filepath.Join(l.Path, "node_modules")
 	ctx.RemoveAll("node_modules")
 
-	lockfile := nodejs.EnsureLockfile(ctx)
+	npmrc, removeNPMRC, err := nodejs.RegistryConfig(ctx)
+	if err != nil {
+		return fmt.Errorf("configuring npm registry: %w", err)
+	}
+	defer removeNPMRC()
+
+	lockfile, err := nodejs.EnsureLockfile(ctx, npmrc)
+	if err != nil {
+		return err
+	}
 
 	nodeEnv := nodejs.EnvDevelopment
 	cached, err := nodejs.CheckCache(ctx, l, cache.WithStrings(nodeEnv), cache.WithFiles("package.json", lockfile))
@@ -65,17 +82,23 @@ func buildFn(ctx *gcp.Context) error {
 		ctx.CacheHit(cacheTag)
 		// Restore cached node_modules.
 		ctx.Exec([]string{"cp", "--archive", nm, "node_modules"}, gcp.WithUserTimingAttribution)
+		nodejs.RebuildNativeAddons(ctx, l, gcp.WithEnv("NODE_ENV="+nodeEnv), npmrc)
 	} else {
 		ctx.CacheMiss(cacheTag)
 		// Clear cached node_modules to ensure we don't end up with outdated dependencies.
 		ctx.ClearLayer(l)
-		ctx.Exec([]string{"npm", nodejs.NPMInstallCommand(ctx), "--quiet"}, gcp.WithEnv("NODE_ENV="+nodeEnv), gcp.WithUserAttribution)
+		ctx.Exec([]string{"npm", nodejs.NPMInstallCommand(ctx), "--quiet"}, gcp.WithEnv("NODE_ENV="+nodeEnv), nodejs.WithNativeAddonTips(ctx), gcp.WithUserAttribution, npmrc)
 		// Ensure node_modules exists even if no dependencies were installed.
 		ctx.MkdirAll("node_modules", 0755)
 		ctx.Exec([]string{"cp", "--archive", "node_modules", nm}, gcp.WithUserTimingAttribution)
 	}
 
 	ctx.Exec([]string{"npm", "run", "gcp-build"}, gcp.WithUserAttribution)
-	ctx.RemoveAll("node_modules")
-	return nil
+	if nodejs.NodeEnv() != nodejs.EnvProduction {
+		// The npm buildpack that runs next installs the dependencies for NODE_ENV.
+		ctx.RemoveAll("node_modules")
+		return nil
+	}
+	// The npm buildpack that runs next keeps the pruned node_modules.
+	return nodejs.PruneDevDependencies(ctx, lockfile, []string{"npm", "prune", "--production"}, npmrc)
 }
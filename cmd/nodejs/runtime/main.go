@@ -20,11 +20,14 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/GoogleCloudPlatform/buildpacks/pkg/env"
 	gcp "github.com/GoogleCloudPlatform/buildpacks/pkg/gcpbuildpack"
 	"github.com/GoogleCloudPlatform/buildpacks/pkg/nodejs"
 	"github.com/GoogleCloudPlatform/buildpacks/pkg/runtime"
+	"github.com/blang/semver"
 	"github.com/buildpacks/libcnb"
 )
 
@@ -90,29 +93,108 @@ func buildFn(ctx *gcp.Context) error {
 	return nil
 }
 
+// versionSource is a declaration of the Node.js version to install.
+type versionSource struct {
+	name    string
+	version string
+}
+
 // runtimeVersion returns the version of the runtime to install.
-// The version is read from env var if set or determined based on the `engines` field in package.json.
+// The version is taken from the first of the following sources that declares one:
+//  1. the FUNC_RUNTIME_VERSION env var, used as is;
+//  2. the `engines.node` field in package.json;
+//  3. the `volta.node` field in package.json;
+//  4. the .nvmrc file;
+//  5. the .node-version file.
+//
+// Versions declared in files are resolved as semver ranges. If no source declares a version, the
+// latest version in the default range is used.
 func runtimeVersion(ctx *gcp.Context) (string, error) {
 	if version := os.Getenv(env.RuntimeVersion); version != "" {
 		ctx.Logf("Using runtime version from %s: %s", env.RuntimeVersion, version)
 		return version, nil
 	}
-	// The default empty range returns the latest version.
-	var versionRange string
-	if ctx.FileExists("package.json") {
-		pjs, err := nodejs.ReadPackageJSON(ctx.ApplicationRoot())
-		if err != nil {
-			return "", fmt.Errorf("reading package.json: %w", err)
-		}
-		versionRange = pjs.Engines.Node
+	sources, err := declaredVersions(ctx)
+	if err != nil {
+		return "", err
 	}
-	if versionRange == "" {
-		versionRange = defaultRange
+	versionRange, source := defaultRange, "default"
+	if len(sources) > 0 {
+		versionRange, source = sources[0].version, sources[0].name
+		if conflicting := disagreeing(sources); len(conflicting) > 0 {
+			ctx.Warnf("Node.js version sources disagree: %s. Using %q from %s.", strings.Join(conflicting, ", "), versionRange, source)
+		}
 	}
-	// Use package.json and semver.io to determine best-fit Node.js version.
-	ctx.Logf("Resolving Node.js version based on semver %q", versionRange)
+	// Use semver.io to determine best-fit Node.js version.
+	ctx.Logf("Resolving Node.js version based on semver %q from %s", versionRange, source)
 	result := ctx.Exec([]string{"curl", "--fail", "--show-error", "--silent", "--location", "--get", "--data-urlencode", fmt.Sprintf("range=%s", versionRange), "http://semver.io/node/resolve"}, gcp.WithUserAttribution)
 	version := result.Stdout
-	ctx.Logf("Using resolved runtime version from package.json: %s", version)
+	ctx.Logf("Using resolved runtime version from %s: %s", source, version)
 	return version, nil
 }
+
+// declaredVersions returns the Node.js versions declared by the application, in order of precedence.
+func declaredVersions(ctx *gcp.Context) ([]versionSource, error) {
+	var sources []versionSource
+	if ctx.FileExists(ctx.ApplicationRoot(), "package.json") {
+		pjs, err := nodejs.ReadPackageJSON(ctx.ApplicationRoot())
+		if err != nil {
+			return nil, fmt.Errorf("reading package.json: %w", err)
+		}
+		sources = append(sources, versionSource{"package.json engines.node", pjs.Engines.Node})
+		sources = append(sources, versionSource{"package.json volta.node", pjs.Volta.Node})
+	}
+	for _, f := range []string{".nvmrc", ".node-version"} {
+		if !ctx.FileExists(ctx.ApplicationRoot(), f) {
+			continue
+		}
+		v := strings.TrimSpace(string(ctx.ReadFile(filepath.Join(ctx.ApplicationRoot(), f))))
+		// nvm aliases such as `lts/*` or `node` cannot be resolved as semver ranges.
+		if strings.HasPrefix(v, "lts/") || v == "node" || v == "stable" {
+			ctx.Warnf("Ignoring unsupported Node.js version alias %q in %s, please specify a version.", v, f)
+			continue
+		}
+		sources = append(sources, versionSource{f, v})
+	}
+
+	var declared []versionSource
+	for _, s := range sources {
+		if s.version = strings.TrimSpace(s.version); s.version != "" {
+			declared = append(declared, s)
+		}
+	}
+	return declared, nil
+}
+
+// disagreeing returns a description of each source if a source conflicts with the first one, which wins,
+// and nil otherwise. Sources conflict when one pins a version outside the range of the other; two ranges
+// never conflict.
+func disagreeing(sources []versionSource) []string {
+	var descriptions []string
+	differ := false
+	for _, s := range sources {
+		if conflict(sources[0].version, s.version) {
+			differ = true
+		}
+		descriptions = append(descriptions, fmt.Sprintf("%s=%q", s.name, s.version))
+	}
+	if !differ {
+		return nil
+	}
+	return descriptions
+}
+
+// conflict returns true if either of the declared versions is pinned to a version that does not
+// satisfy the range of the other.
+func conflict(a, b string) bool {
+	for _, pair := range [][2]string{{a, b}, {b, a}} {
+		pinned, rng := strings.TrimPrefix(pair[0], "v"), pair[1]
+		if _, err := semver.Parse(pinned); err != nil {
+			continue
+		}
+		if matches, checkable := nodejs.Satisfies(pinned, rng); checkable && !matches {
+			return true
+		}
+	}
+	return false
+}
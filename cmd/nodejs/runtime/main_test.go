@@ -15,9 +15,14 @@
 package main
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
 	"testing"
 
 	gcp "github.com/GoogleCloudPlatform/buildpacks/pkg/gcpbuildpack"
+	"github.com/buildpacks/libcnb"
 )
 
 func TestDetect(t *testing.T) {
@@ -55,3 +60,115 @@ func TestDetect(t *testing.T) {
 		})
 	}
 }
+
+func TestDeclaredVersions(t *testing.T) {
+	testCases := []struct {
+		name  string
+		files map[string]string
+		want  []versionSource
+	}{
+		{
+			name:  "nothing declared",
+			files: map[string]string{"index.js": ""},
+		},
+		{
+			name: "all sources in order of precedence",
+			files: map[string]string{
+				"package.json":  `{"engines": {"node": "14.x"}, "volta": {"node": "14.17.0"}}`,
+				".nvmrc":        "v14.17.0\n",
+				".node-version": "14.17.0",
+			},
+			want: []versionSource{
+				{"package.json engines.node", "14.x"},
+				{"package.json volta.node", "14.17.0"},
+				{".nvmrc", "v14.17.0"},
+				{".node-version", "14.17.0"},
+			},
+		},
+		{
+			name: "nvmrc without package.json",
+			files: map[string]string{
+				".nvmrc": "16",
+			},
+			want: []versionSource{
+				{".nvmrc", "16"},
+			},
+		},
+		{
+			name: "nvmrc alias is ignored",
+			files: map[string]string{
+				".nvmrc":        "lts/*",
+				".node-version": "16.13.0",
+			},
+			want: []versionSource{
+				{".node-version", "16.13.0"},
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "declared-versions-")
+			if err != nil {
+				t.Fatalf("creating temp dir: %v", err)
+			}
+			defer os.RemoveAll(dir)
+			for f, c := range tc.files {
+				if err := ioutil.WriteFile(filepath.Join(dir, f), []byte(c), 0644); err != nil {
+					t.Fatalf("writing %s: %v", f, err)
+				}
+			}
+			ctx := gcp.NewContextForTests(libcnb.BuildpackInfo{}, dir)
+
+			got, err := declaredVersions(ctx)
+			if err != nil {
+				t.Fatalf("declaredVersions() got error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("declaredVersions() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestDisagreeing(t *testing.T) {
+	testCases := []struct {
+		name    string
+		sources []versionSource
+		want    []string
+	}{
+		{
+			name:    "single source",
+			sources: []versionSource{{".nvmrc", "16"}},
+		},
+		{
+			name:    "same version with v prefix",
+			sources: []versionSource{{".nvmrc", "v16.13.0"}, {".node-version", "16.13.0"}},
+		},
+		{
+			name:    "pinned version satisfies the winning range",
+			sources: []versionSource{{"package.json engines.node", ">=14"}, {".nvmrc", "v16.13.0"}},
+		},
+		{
+			name:    "different ranges",
+			sources: []versionSource{{"package.json engines.node", "14.x"}, {".nvmrc", "16"}},
+		},
+		{
+			name:    "pinned version outside the winning range",
+			sources: []versionSource{{"package.json engines.node", "14.x"}, {".nvmrc", "16.13.0"}},
+			want:    []string{`package.json engines.node="14.x"`, `.nvmrc="16.13.0"`},
+		},
+		{
+			name:    "winning pinned version outside a range",
+			sources: []versionSource{{"package.json engines.node", "18.12.1"}, {".node-version", "^16"}},
+			want:    []string{`package.json engines.node="18.12.1"`, `.node-version="^16"`},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := disagreeing(tc.sources)
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("disagreeing() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
@@ -51,15 +51,66 @@ func detectFn(ctx *gcp.Context) (gcp.DetectResult, error) {
 }
 
 func buildFn(ctx *gcp.Context) error {
-	if err := installYarn(ctx); err != nil {
+	pjs, err := nodejs.ReadPackageJSON(ctx.ApplicationRoot())
+	if err != nil {
+		return fmt.Errorf("reading package.json: %w", err)
+	}
+	// Yarn 2 and later ("berry") can only be activated through Corepack.
+	berry := false
+	activated, err := nodejs.ActivatePackageManager(ctx, pjs, "yarn")
+	if err != nil {
+		return fmt.Errorf("activating Yarn: %w", err)
+	}
+	if activated {
+		_, version, _ := nodejs.PackageManager(pjs)
+		berry = nodejs.YarnBerry(version)
+	} else if err := installYarn(ctx); err != nil {
 		return fmt.Errorf("installing Yarn: %w", err)
 	}
 
+	nodeEnv := nodejs.NodeEnv()
+	if !nodejs.UsePrunedDependencies(ctx) {
+		if err := installDependencies(ctx, nodeEnv, berry); err != nil {
+			return err
+		}
+	}
+
+	el := ctx.Layer("env", gcp.BuildLayer, gcp.LaunchLayer)
+	el.SharedEnvironment.Default("PATH", filepath.Join(ctx.ApplicationRoot(), "node_modules", ".bin"))
+	el.SharedEnvironment.Default("NODE_ENV", nodeEnv)
+
+	// Configure the entrypoint for production.
+	cmd := []string{"yarn", "run", "start"}
+
+	if !devmode.Enabled(ctx) {
+		ctx.AddWebProcess(cmd)
+		return nil
+	}
+
+	// Configure the entrypoint and metadata for dev mode.
+	devmode.AddFileWatcherProcess(ctx, devmode.Config{
+		RunCmd: cmd,
+		Ext:    devmode.NodeWatchedExtensions,
+	})
+	devmode.AddSyncMetadata(ctx, devmode.NodeSyncRules)
+
+	return nil
+}
+
+// installDependencies installs the dependencies for the given NODE_ENV into node_modules with Yarn 1 or,
+// if berry is true, a later Yarn, restoring them from the cache layer when package.json and yarn.lock
+// are unchanged.
+func installDependencies(ctx *gcp.Context, nodeEnv string, berry bool) error {
 	ml := ctx.Layer("yarn", gcp.BuildLayer, gcp.CacheLayer)
 	nm := filepath.Join(ml.Path, "node_modules")
 	ctx.RemoveAll("node_modules")
 
-	nodeEnv := nodejs.NodeEnv()
+	npmrc, removeNPMRC, err := nodejs.RegistryConfig(ctx)
+	if err != nil {
+		return fmt.Errorf("configuring npm registry: %w", err)
+	}
+	defer removeNPMRC()
+
 	cached, err := nodejs.CheckCache(ctx, ml, cache.WithStrings(nodeEnv), cache.WithFiles("package.json", nodejs.YarnLock))
 	if err != nil {
 		return fmt.Errorf("checking cache: %w", err)
@@ -69,6 +120,7 @@ func buildFn(ctx *gcp.Context) error {
 		ctx.CacheHit(cacheTag)
 		// Restore cached node_modules.
 		ctx.Exec([]string{"cp", "--archive", nm, "node_modules"}, gcp.WithUserTimingAttribution)
+		nodejs.RebuildNativeAddons(ctx, ml, gcp.WithEnv("NODE_ENV="+nodeEnv), npmrc)
 	} else {
 		ctx.CacheMiss(cacheTag)
 		// Clear cached node_modules to ensure we don't end up with outdated dependencies.
@@ -77,36 +129,19 @@ func buildFn(ctx *gcp.Context) error {
 
 	// Always run yarn install to run preinstall/postinstall scripts.
 	cmd := []string{"yarn", "install", "--non-interactive"}
-	if lf := nodejs.LockfileFlag(ctx); lf != "" {
+	if berry {
+		// Yarn berry is non-interactive by default and replaces --frozen-lockfile with --immutable.
+		cmd = []string{"yarn", "install", "--immutable"}
+	} else if lf := nodejs.LockfileFlag(ctx); lf != "" {
 		cmd = append(cmd, lf)
 	}
-	ctx.Exec(cmd, gcp.WithEnv("NODE_ENV="+nodeEnv), gcp.WithUserAttribution)
+	ctx.Exec(cmd, gcp.WithEnv("NODE_ENV="+nodeEnv), nodejs.WithNativeAddonTips(ctx), gcp.WithUserAttribution, npmrc)
 
 	if !cached {
 		// Ensure node_modules exists even if no dependencies were installed.
 		ctx.MkdirAll("node_modules", 0755)
 		ctx.Exec([]string{"cp", "--archive", "node_modules", nm}, gcp.WithUserTimingAttribution)
 	}
-
-	el := ctx.Layer("env", gcp.BuildLayer, gcp.LaunchLayer)
-	el.SharedEnvironment.Default("PATH", filepath.Join(ctx.ApplicationRoot(), "node_modules", ".bin"))
-	el.SharedEnvironment.Default("NODE_ENV", nodeEnv)
-
-	// Configure the entrypoint for production.
-	cmd = []string{"yarn", "run", "start"}
-
-	if !devmode.Enabled(ctx) {
-		ctx.AddWebProcess(cmd)
-		return nil
-	}
-
-	// Configure the entrypoint and metadata for dev mode.
-	devmode.AddFileWatcherProcess(ctx, devmode.Config{
-		RunCmd: cmd,
-		Ext:    devmode.NodeWatchedExtensions,
-	})
-	devmode.AddSyncMetadata(ctx, devmode.NodeSyncRules)
-
 	return nil
 }
 
@@ -53,10 +53,31 @@ func detectFn(ctx *gcp.Context) (gcp.DetectResult, error) {
 }
 
 func buildFn(ctx *gcp.Context) error {
+	pjs, err := nodejs.ReadPackageJSON(ctx.ApplicationRoot())
+	if err != nil {
+		return fmt.Errorf("reading package.json: %w", err)
+	}
+	// Yarn 2 and later ("berry") can only be activated through Corepack.
+	berry := false
+	activated, err := nodejs.ActivatePackageManager(ctx, pjs, "yarn")
+	if err != nil {
+		return fmt.Errorf("activating Yarn: %w", err)
+	}
+	if activated {
+		_, version, _ := nodejs.PackageManager(pjs)
+		berry = nodejs.YarnBerry(version)
+	}
+
 	l := ctx.Layer("yarn", gcp.CacheLayer)
 	nm := filepath.Join(l.Path, "node_modules")
 	ctx.RemoveAll("node_modules")
 
+	npmrc, removeNPMRC, err := nodejs.RegistryConfig(ctx)
+	if err != nil {
+		return fmt.Errorf("configuring npm registry: %w", err)
+	}
+	defer removeNPMRC()
+
 	nodeEnv := nodejs.EnvDevelopment
 	cached, err := nodejs.CheckCache(ctx, l, cache.WithStrings(nodeEnv), cache.WithFiles("package.json", nodejs.YarnLock))
 	if err != nil {
@@ -67,16 +88,20 @@ func buildFn(ctx *gcp.Context) error {
 		ctx.Logf("Due to cache hit, package.json scripts will not be run. To run the scripts, disable caching.")
 		// Restore cached node_modules.
 		ctx.Exec([]string{"cp", "--archive", nm, "node_modules"}, gcp.WithUserTimingAttribution)
+		nodejs.RebuildNativeAddons(ctx, l, gcp.WithEnv("NODE_ENV="+nodeEnv), npmrc)
 	} else {
 		ctx.CacheMiss(cacheTag)
 		// Clear cached node_modules to ensure we don't end up with outdated dependencies.
 		ctx.ClearLayer(l)
 
 		cmd := []string{"yarn", "install", "--non-interactive"}
-		if lf := nodejs.LockfileFlag(ctx); lf != "" {
+		if berry {
+			// Yarn berry is non-interactive by default and replaces --frozen-lockfile with --immutable.
+			cmd = []string{"yarn", "install", "--immutable"}
+		} else if lf := nodejs.LockfileFlag(ctx); lf != "" {
 			cmd = append(cmd, lf)
 		}
-		ctx.Exec(cmd, gcp.WithEnv("NODE_ENV="+nodeEnv), gcp.WithUserAttribution)
+		ctx.Exec(cmd, gcp.WithEnv("NODE_ENV="+nodeEnv), nodejs.WithNativeAddonTips(ctx), gcp.WithUserAttribution, npmrc)
 
 		// Ensure node_modules exists even if no dependencies were installed.
 		ctx.MkdirAll("node_modules", 0755)
@@ -84,6 +109,17 @@ func buildFn(ctx *gcp.Context) error {
 	}
 
 	ctx.Exec([]string{"yarn", "run", "gcp-build"}, gcp.WithUserAttribution)
-	ctx.RemoveAll("node_modules")
-	return nil
+	if nodejs.NodeEnv() != nodejs.EnvProduction || berry {
+		// The yarn buildpack that runs next installs the dependencies for NODE_ENV. Yarn berry only
+		// prunes devDependencies with `yarn workspaces focus`, a plugin before Yarn 4.
+		ctx.RemoveAll("node_modules")
+		return nil
+	}
+	// Yarn removes the devDependencies when it installs production dependencies into node_modules.
+	prune := []string{"yarn", "install", "--production", "--non-interactive"}
+	if lf := nodejs.LockfileFlag(ctx); lf != "" {
+		prune = append(prune, lf)
+	}
+	// The yarn buildpack that runs next keeps the pruned node_modules.
+	return nodejs.PruneDevDependencies(ctx, nodejs.YarnLock, prune, npmrc)
 }
@@ -31,6 +31,12 @@ const (
 )
 
 var (
+	// frameworkApp is the WSGI app that functions-framework creates for the function configured by
+	// the FUNCTION_TARGET, FUNCTION_SOURCE and FUNCTION_SIGNATURE_TYPE environment variables. Gunicorn
+	// loads app factories since 20.1.0, the version of the converter requirements; the pip buildpack
+	// checks the version that the function's own requirements install.
+	frameworkApp = &python.App{Ref: "functions_framework:create_app()"}
+
 	ffRegexp  = regexp.MustCompile(`(?m)^functions-framework\b([^-]|$)`)
 	eggRegexp = regexp.MustCompile(`(?m)#egg=functions-framework$`)
 )
@@ -76,7 +82,9 @@ func buildFn(ctx *gcp.Context) error {
 	}
 
 	ctx.SetFunctionsEnvVars(l)
-	ctx.AddDefaultWebProcess([]string{"functions-framework"}, true)
+	// The function is served by gunicorn, which functions-framework depends on, rather than by the
+	// functions-framework command, so that the workers and threads match the container limits.
+	python.AddWebServerProcess(ctx, python.ServerCommand(frameworkApp, python.Gunicorn))
 	return nil
 }
 
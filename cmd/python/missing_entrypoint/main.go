@@ -13,15 +13,14 @@
 // limitations under the License.
 
 // Implements python/missing-entrypoint buildpack.
-// This buildpack's goal is to display a clear error message when
-// no entrypoint is defined on a Python application.
+// This buildpack sets the entrypoint of Python applications that do not define one to serve their
+// WSGI or ASGI app with a production web server, and displays a clear error message when no app is found.
 package main
 
 import (
-	"fmt"
-
 	"github.com/GoogleCloudPlatform/buildpacks/pkg/env"
 	gcp "github.com/GoogleCloudPlatform/buildpacks/pkg/gcpbuildpack"
+	"github.com/GoogleCloudPlatform/buildpacks/pkg/python"
 )
 
 func main() {
@@ -33,5 +32,19 @@ func detectFn(ctx *gcp.Context) (gcp.DetectResult, error) {
 }
 
 func buildFn(ctx *gcp.Context) error {
-	return fmt.Errorf("for Python, an entrypoint must be manually set, either with %q env var or by creating a %q file", env.Entrypoint, "Procfile")
+	app := python.DetectApp(ctx)
+	if app == nil {
+		return gcp.UserErrorf("for Python, an entrypoint must be manually set, either with %q env var or by creating a %q file, unless main.py defines a WSGI or ASGI `app` or the application is a Django project", env.Entrypoint, "Procfile")
+	}
+	server, err := python.InstalledServer(ctx, app)
+	if err != nil {
+		return err
+	}
+	kind := "WSGI"
+	if app.ASGI {
+		kind = "ASGI"
+	}
+	ctx.Logf("Serving %s app %q with %s.", kind, app.Ref, server)
+	python.AddWebServerProcess(ctx, python.ServerCommand(app, server))
+	return nil
 }
@@ -22,6 +22,7 @@ import (
 	"path/filepath"
 	"strings"
 
+	"github.com/GoogleCloudPlatform/buildpacks/pkg/env"
 	gcp "github.com/GoogleCloudPlatform/buildpacks/pkg/gcpbuildpack"
 	"github.com/GoogleCloudPlatform/buildpacks/pkg/python"
 	"github.com/buildpacks/libcnb"
@@ -44,10 +45,10 @@ func main() {
 
 func detectFn(ctx *gcp.Context) (gcp.DetectResult, error) {
 	plan := libcnb.BuildPlan{Requires: python.RequirementsRequires}
-	// If a requirement.txt file exists, the buildpack needs to provide the Requirements dependency.
-	// If the dependency is not provided by any buildpacks, lifecycle will exclude the pip
-	// buildpack from the build.
-	if ctx.FileExists("requirements.txt") {
+	// If a requirement.txt, Pipfile.lock or pyproject.toml file exists, the buildpack needs to provide
+	// the Requirements dependency. If the dependency is not provided by any buildpacks, lifecycle will
+	// exclude the pip buildpack from the build.
+	if ctx.FileExists("requirements.txt") || ctx.FileExists(python.PipfileLock) || ctx.FileExists(python.PyprojectTOML) {
 		plan.Provides = python.RequirementsProvides
 	}
 	return gcp.OptInAlways(gcp.WithBuildPlans(plan)), nil
@@ -57,18 +58,50 @@ func buildFn(ctx *gcp.Context) error {
 	// Remove leading and trailing : because otherwise SplitList will add empty strings.
 	reqs := filepath.SplitList(strings.Trim(os.Getenv(python.RequirementsFilesEnv), string(os.PathListSeparator)))
 
-	// The workspace requirements.txt file should be installed last.
-	if ctx.FileExists("requirements.txt") {
-		reqs = append(reqs, "requirements.txt")
+	// The workspace requirements should be installed last.
+	userReqs, err := workspaceRequirements(ctx)
+	if err != nil {
+		return err
+	}
+	if userReqs != "" {
+		reqs = append(reqs, userReqs)
 	}
 
 	l := ctx.Layer(layerName, gcp.BuildLayer, gcp.CacheLayer, gcp.LaunchLayer)
 
-	err := python.InstallRequirements(ctx, l, reqs...)
-	if err != nil {
+	if err := python.InstallRequirements(ctx, l, reqs...); err != nil {
 		return fmt.Errorf("installing dependencies: %w", err)
 	}
 
+	if err := checkDependencies(ctx); err != nil {
+		return err
+	}
+
+	// Functions are validated here rather than in the functions-framework buildpack, which runs before
+	// the dependencies that the function source imports are installed.
+	if target, ok := os.LookupEnv(env.FunctionTarget); ok {
+		source := "main.py"
+		if s, ok := os.LookupEnv(env.FunctionSource); ok {
+			source = s
+		}
+		signatureType := "http"
+		if t, ok := os.LookupEnv(env.FunctionSignatureType); ok {
+			signatureType = strings.ToLower(t)
+		}
+		// The functions-framework buildpack serves the function with gunicorn, whatever version the
+		// function's requirements pin.
+		if err := python.CheckFactoryServer(ctx, l); err != nil {
+			return err
+		}
+		if err := python.ValidateFunctionTarget(ctx, l, source, target, signatureType); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkDependencies returns a user error if the installed dependencies are incompatible.
+func checkDependencies(ctx *gcp.Context) error {
 	ctx.Logf("Checking for incompatible dependencies.")
 	result, err := ctx.ExecWithErr([]string{"python3", "-m", "pip", "check"}, gcp.WithUserAttribution)
 	if result == nil {
@@ -83,5 +116,32 @@ func buildFn(ctx *gcp.Context) error {
 		return nil
 	}
 	return gcp.UserErrorf("found incompatible dependencies: %q", result.Stdout)
+}
 
+// workspaceRequirements returns the requirements file of the application, if any. The first of
+// requirements.txt, Pipfile.lock and pyproject.toml that exists declares the requirements; the latter
+// two are exported to a requirements file.
+func workspaceRequirements(ctx *gcp.Context) (string, error) {
+	if ctx.FileExists("requirements.txt") {
+		for _, f := range []string{python.PipfileLock, python.PyprojectTOML} {
+			if ctx.FileExists(f) {
+				ctx.Logf("Installing dependencies from requirements.txt, ignoring %s.", f)
+			}
+		}
+		return "requirements.txt", nil
+	}
+	if ctx.FileExists(python.PipfileLock) || ctx.FileExists(python.Pipfile) {
+		exported, err := python.ExportPipfileLock(ctx, ctx.TempDir("", "requirements-"))
+		if err != nil {
+			return "", fmt.Errorf("exporting %s: %w", python.PipfileLock, err)
+		}
+		if exported != "" {
+			return exported, nil
+		}
+	}
+	exported, err := python.ExportProjectRequirements(ctx, ctx.TempDir("", "requirements-"))
+	if err != nil {
+		return "", fmt.Errorf("exporting %s dependencies: %w", python.PyprojectTOML, err)
+	}
+	return exported, nil
 }
@@ -34,6 +34,24 @@ func TestDetect(t *testing.T) {
 			},
 			want: 0,
 		},
+		{
+			name: "pyproject file",
+			files: map[string]string{
+				"main.py":        "",
+				"pyproject.toml": "",
+				"poetry.lock":    "",
+			},
+			want: 0,
+		},
+		{
+			name: "pipfile lock",
+			files: map[string]string{
+				"main.py":      "",
+				"Pipfile":      "",
+				"Pipfile.lock": "",
+			},
+			want: 0,
+		},
 		{
 			// Opt-in with no requirements in case there's a build plan.
 			name: "no requirements",
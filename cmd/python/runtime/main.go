@@ -0,0 +1,171 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Implements python/runtime buildpack.
+// The runtime buildpack installs the Python interpreter.
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/buildpacks/pkg/env"
+	gcp "github.com/GoogleCloudPlatform/buildpacks/pkg/gcpbuildpack"
+	"github.com/GoogleCloudPlatform/buildpacks/pkg/runtime"
+	"github.com/buildpacks/libcnb"
+)
+
+const (
+	pythonLayer      = "python"
+	pythonTarballURL = "https://storage.googleapis.com/gcp-buildpacks/python/python-%s.tar.gz"
+	pythonVersionURL = "https://storage.googleapis.com/gcp-buildpacks/python/latest.version"
+	versionKey       = "version"
+)
+
+var (
+	// versionRegexp matches the exact Python versions that can be installed, e.g. `3.9.7`.
+	versionRegexp = regexp.MustCompile(`^\d+\.\d+\.\d+$`)
+	// versionFiles are the files that declare the Python version, in order of precedence.
+	versionFiles = []string{".python-version", "runtime.txt"}
+)
+
+func main() {
+	gcp.Main(detectFn, buildFn)
+}
+
+func detectFn(ctx *gcp.Context) (gcp.DetectResult, error) {
+	if result := runtime.CheckOverride(ctx, "python"); result != nil {
+		return result, nil
+	}
+	if !ctx.FileExists("requirements.txt") && len(ctx.Glob("*.py")) == 0 {
+		return gcp.OptOut("neither requirements.txt nor any .py files found"), nil
+	}
+	// Builders pinned to a Python version ship it in their build image; keep it unless the application asks for another.
+	if stackVersion := os.Getenv(env.StackPythonVersion); stackVersion != "" && !versionDeclared(ctx) {
+		return gcp.OptOut(fmt.Sprintf("using Python %s from the stack, no runtime version declared", stackVersion)), nil
+	}
+	if ctx.FileExists("requirements.txt") {
+		return gcp.OptInFileFound("requirements.txt"), nil
+	}
+	return gcp.OptIn("found .py files"), nil
+}
+
+// versionDeclared returns true if the application declares a Python version with FUNC_RUNTIME_VERSION,
+// .python-version or runtime.txt.
+func versionDeclared(ctx *gcp.Context) bool {
+	if os.Getenv(env.RuntimeVersion) != "" {
+		return true
+	}
+	for _, f := range versionFiles {
+		if ctx.FileExists(ctx.ApplicationRoot(), f) {
+			return true
+		}
+	}
+	return false
+}
+
+func buildFn(ctx *gcp.Context) error {
+	version, err := runtimeVersion(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Check the metadata in the cache layer to determine if we need to proceed.
+	l := ctx.Layer(pythonLayer, gcp.BuildLayer, gcp.CacheLayer, gcp.LaunchLayer)
+	metaVersion := ctx.GetMetadata(l, versionKey)
+	if version == metaVersion {
+		ctx.CacheHit(pythonLayer)
+		ctx.Logf("Runtime cache hit, skipping installation.")
+		return nil
+	}
+	ctx.CacheMiss(pythonLayer)
+	ctx.ClearLayer(l)
+
+	archiveURL := fmt.Sprintf(pythonTarballURL, version)
+	if code := ctx.HTTPStatus(archiveURL); code != http.StatusOK {
+		return gcp.UserErrorf("Runtime version %s does not exist at %s (status %d). You can specify the version with %s.", version, archiveURL, code, env.RuntimeVersion)
+	}
+
+	// Download and install Python in layer. Subsequent buildpacks find python3 in the layer's bin,
+	// which the lifecycle adds to PATH, so the Python version in their dependency caches is accurate.
+	ctx.Logf("Installing Python v%s", version)
+	command := fmt.Sprintf("curl --fail --show-error --silent --location --retry 3 %s | tar xz --directory %s", archiveURL, l.Path)
+	ctx.Exec([]string{"bash", "-c", command}, gcp.WithUserAttribution)
+
+	ctx.SetMetadata(l, versionKey, version)
+	ctx.AddBOMEntry(libcnb.BOMEntry{
+		Name:     pythonLayer,
+		Metadata: map[string]interface{}{"version": version},
+	})
+	return nil
+}
+
+// runtimeVersion returns the version of the runtime to install.
+// The version is taken from the first of the FUNC_RUNTIME_VERSION env var, the .python-version
+// file and the runtime.txt file that declares one. If none does, the latest version is used.
+func runtimeVersion(ctx *gcp.Context) (string, error) {
+	if version := os.Getenv(env.RuntimeVersion); version != "" {
+		ctx.Logf("Using runtime version from %s: %s", env.RuntimeVersion, version)
+		return version, nil
+	}
+	version, source, err := declaredVersion(ctx)
+	if err != nil {
+		return "", err
+	}
+	if version != "" {
+		ctx.Logf("Using runtime version from %s: %s", source, version)
+		return version, nil
+	}
+	result := ctx.Exec([]string{"curl", "--fail", "--show-error", "--silent", "--location", pythonVersionURL}, gcp.WithUserAttribution)
+	version = strings.TrimSpace(result.Stdout)
+	ctx.Logf("Using latest runtime version: %s", version)
+	return version, nil
+}
+
+// declaredVersion returns the Python version declared in .python-version or runtime.txt, and the
+// name of the file that declares it. The version is empty if neither file exists.
+func declaredVersion(ctx *gcp.Context) (string, string, error) {
+	for _, f := range versionFiles {
+		if !ctx.FileExists(ctx.ApplicationRoot(), f) {
+			continue
+		}
+		version := parseVersionFile(f, string(ctx.ReadFile(filepath.Join(ctx.ApplicationRoot(), f))))
+		if !versionRegexp.MatchString(version) {
+			return "", "", gcp.UserErrorf("invalid Python version %q in %s, expected an exact version such as 3.9.7", version, f)
+		}
+		return version, f, nil
+	}
+	return "", "", nil
+}
+
+// parseVersionFile returns the version declared in the contents of the given version file.
+// pyenv's .python-version holds the version on the first line that is not a comment,
+// runtime.txt holds it prefixed with `python-`, e.g. `python-3.9.7`.
+func parseVersionFile(name, contents string) string {
+	for _, line := range strings.Split(contents, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if name == "runtime.txt" {
+			return strings.TrimPrefix(line, "python-")
+		}
+		return line
+	}
+	return ""
+}
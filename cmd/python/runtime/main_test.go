@@ -0,0 +1,170 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	gcp "github.com/GoogleCloudPlatform/buildpacks/pkg/gcpbuildpack"
+	"github.com/buildpacks/libcnb"
+)
+
+func TestDetect(t *testing.T) {
+	testCases := []struct {
+		name  string
+		files map[string]string
+		env   []string
+		want  int
+	}{
+		{
+			name: "requirements.txt",
+			files: map[string]string{
+				"requirements.txt": "",
+			},
+			want: 0,
+		},
+		{
+			name: "python files",
+			files: map[string]string{
+				"main.py": "",
+			},
+			want: 0,
+		},
+		{
+			name: "other runtime",
+			files: map[string]string{
+				"main.py": "",
+			},
+			env:  []string{"FUNC_RUNTIME=nodejs"},
+			want: 100,
+		},
+		{
+			name: "no python files",
+			files: map[string]string{
+				"index.js":        "",
+				".python-version": "3.9.7",
+			},
+			want: 100,
+		},
+		{
+			name: "stack python",
+			files: map[string]string{
+				"main.py": "",
+			},
+			env:  []string{"FUNC_STACK_PYTHON_VERSION=3.9.5"},
+			want: 100,
+		},
+		{
+			name: "stack python with version file",
+			files: map[string]string{
+				"main.py":     "",
+				"runtime.txt": "python-3.9.7",
+			},
+			env:  []string{"FUNC_STACK_PYTHON_VERSION=3.9.5"},
+			want: 0,
+		},
+		{
+			name: "stack python with runtime version",
+			files: map[string]string{
+				"main.py": "",
+			},
+			env:  []string{"FUNC_STACK_PYTHON_VERSION=3.9.5", "FUNC_RUNTIME_VERSION=3.10.0"},
+			want: 0,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			gcp.TestDetect(t, detectFn, tc.name, tc.files, tc.env, tc.want)
+		})
+	}
+}
+
+func TestDeclaredVersion(t *testing.T) {
+	testCases := []struct {
+		name       string
+		files      map[string]string
+		want       string
+		wantSource string
+		wantErr    bool
+	}{
+		{
+			name:  "nothing declared",
+			files: map[string]string{"main.py": ""},
+		},
+		{
+			name:       "python-version with comment",
+			files:      map[string]string{".python-version": "# pyenv\n3.9.7\n"},
+			want:       "3.9.7",
+			wantSource: ".python-version",
+		},
+		{
+			name:       "runtime.txt",
+			files:      map[string]string{"runtime.txt": "python-3.8.12\n"},
+			want:       "3.8.12",
+			wantSource: "runtime.txt",
+		},
+		{
+			name: "python-version takes precedence",
+			files: map[string]string{
+				".python-version": "3.9.7",
+				"runtime.txt":     "python-3.8.12",
+			},
+			want:       "3.9.7",
+			wantSource: ".python-version",
+		},
+		{
+			name:    "partial version",
+			files:   map[string]string{".python-version": "3.9"},
+			wantErr: true,
+		},
+		{
+			name:    "pyenv virtualenv",
+			files:   map[string]string{".python-version": "myenv"},
+			wantErr: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "declared-version-")
+			if err != nil {
+				t.Fatalf("creating temp dir: %v", err)
+			}
+			defer os.RemoveAll(dir)
+			for f, c := range tc.files {
+				if err := ioutil.WriteFile(filepath.Join(dir, f), []byte(c), 0644); err != nil {
+					t.Fatalf("writing %s: %v", f, err)
+				}
+			}
+			ctx := gcp.NewContextForTests(libcnb.BuildpackInfo{}, dir)
+
+			got, gotSource, err := declaredVersion(ctx)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("declaredVersion() did not return error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("declaredVersion() got error: %v", err)
+			}
+			if got != tc.want || gotSource != tc.wantSource {
+				t.Errorf("declaredVersion() = (%q, %q), want (%q, %q)", got, gotSource, tc.want, tc.wantSource)
+			}
+		})
+	}
+}
@@ -0,0 +1,394 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Implements utils/apt buildpack.
+// The apt buildpack installs the system packages listed in Aptfile into a layer that is available
+// to the subsequent buildpacks and at run time.
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/buildpacks/pkg/cache"
+	"github.com/GoogleCloudPlatform/buildpacks/pkg/env"
+	gcp "github.com/GoogleCloudPlatform/buildpacks/pkg/gcpbuildpack"
+	"github.com/buildpacks/libcnb"
+)
+
+const (
+	aptfile       = "Aptfile"
+	layerName     = "apt"
+	packageKey    = "package_hash"
+	defaultMirror = "http://archive.ubuntu.com/ubuntu"
+	// portsMirror serves the architectures other than amd64 and i386, which the default mirror lacks.
+	portsMirror = "http://ports.ubuntu.com/ubuntu-ports"
+	osRelease   = "/etc/os-release"
+)
+
+var (
+	// packageRegexp matches a Debian package name with an optional version, e.g. `libpq-dev=10.19-0ubuntu0.18.04.1`.
+	packageRegexp  = regexp.MustCompile(`^[a-z0-9][a-z0-9+.-]+(=[A-Za-z0-9.+~:-]+)?$`)
+	codenameRegexp = regexp.MustCompile(`(?m)^VERSION_CODENAME=(\w+)$`)
+
+	// multiarch is the Debian multiarch directory name for the architecture of the build.
+	multiarch = map[string]string{
+		"amd64": "x86_64-linux-gnu",
+		"arm64": "aarch64-linux-gnu",
+	}[runtime.GOARCH]
+)
+
+func main() {
+	gcp.Main(detectFn, buildFn)
+}
+
+func detectFn(ctx *gcp.Context) (gcp.DetectResult, error) {
+	if ctx.FileExists(aptfile) {
+		return gcp.OptInFileFound(aptfile), nil
+	}
+	return gcp.OptOutFileNotFound(aptfile), nil
+}
+
+func buildFn(ctx *gcp.Context) error {
+	pkgs, err := parseAptfile(string(ctx.ReadFile(filepath.Join(ctx.ApplicationRoot(), aptfile))))
+	if err != nil {
+		return err
+	}
+	l := ctx.Layer(layerName, gcp.BuildLayer, gcp.CacheLayer, gcp.LaunchLayer)
+	if len(pkgs) == 0 {
+		ctx.Warnf("%s does not list any packages.", aptfile)
+		ctx.ClearLayer(l)
+		return nil
+	}
+
+	// Offline builds install the .deb files in a local directory, other builds download them.
+	var debs []string
+	release, err := codename(ctx)
+	if err != nil {
+		return err
+	}
+	opts := []cache.Option{cache.WithStrings(pkgs...), cache.WithStrings(release, runtime.GOARCH)}
+	if dir := os.Getenv(env.AptPackagesDir); dir != "" {
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(ctx.ApplicationRoot(), dir)
+		}
+		if debs, err = localPackages(dir, pkgs); err != nil {
+			return err
+		}
+		opts = append(opts, cache.WithFiles(debs...))
+	} else {
+		opts = append(opts, cache.WithStrings(mirror()))
+	}
+
+	hash, err := cache.Hash(ctx, opts...)
+	if err != nil {
+		return fmt.Errorf("computing package hash: %w", err)
+	}
+	setEnvironment(l)
+	if hash == ctx.GetMetadata(l, packageKey) {
+		ctx.CacheHit(layerName)
+		return nil
+	}
+	ctx.CacheMiss(layerName)
+	ctx.ClearLayer(l)
+
+	if debs == nil {
+		tmp := ctx.TempDir("", "apt")
+		defer ctx.RemoveAll(tmp)
+		debs = downloadPackages(ctx, tmp, release, pkgs)
+	}
+	ctx.Logf("Installing %d packages: %s", len(debs), strings.Join(pkgs, ", "))
+	for _, deb := range debs {
+		ctx.Exec([]string{"dpkg-deb", "--extract", deb, l.Path}, gcp.WithUserAttribution)
+	}
+	relocatePkgConfig(ctx, l.Path)
+	ctx.SetMetadata(l, packageKey, hash)
+	return nil
+}
+
+// parseAptfile returns the packages listed in an Aptfile, one per line. Blank lines and lines
+// starting with # are ignored.
+func parseAptfile(content string) ([]string, error) {
+	var pkgs, invalid []string
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if !packageRegexp.MatchString(line) {
+			invalid = append(invalid, line)
+			continue
+		}
+		pkgs = append(pkgs, line)
+	}
+	if len(invalid) > 0 {
+		return nil, gcp.UserErrorf("invalid package names in %s, expected one name or name=version per line: %s", aptfile, strings.Join(invalid, ", "))
+	}
+	return pkgs, nil
+}
+
+// localPackages returns the .deb file in the directory for each package. The files are expected to
+// follow the Debian naming convention, name_version_architecture.deb. Packages that the run image
+// lacks must be listed in Aptfile, as dependencies are not resolved.
+func localPackages(dir string, pkgs []string) ([]string, error) {
+	var debs, missing []string
+	for _, pkg := range pkgs {
+		name, version := pkg, "*"
+		if i := strings.Index(pkg, "="); i >= 0 {
+			name, version = pkg[:i], strings.ReplaceAll(pkg[i+1:], ":", "%3a")
+		}
+		matches, err := filepath.Glob(filepath.Join(dir, fmt.Sprintf("%s_%s_*.deb", name, version)))
+		if err != nil {
+			return nil, gcp.InternalErrorf("finding .deb file for %s: %v", pkg, err)
+		}
+		if len(matches) == 0 {
+			missing = append(missing, pkg)
+			continue
+		}
+		// Use the latest version if there are several.
+		latest := matches[0]
+		for _, m := range matches[1:] {
+			if compareVersions(debVersion(m), debVersion(latest)) > 0 {
+				latest = m
+			}
+		}
+		debs = append(debs, latest)
+	}
+	if len(missing) > 0 {
+		return nil, gcp.UserErrorf("%s is set, but %s has no .deb file for packages: %s", env.AptPackagesDir, dir, strings.Join(missing, ", "))
+	}
+	return debs, nil
+}
+
+// debVersion returns the version in the name of a .deb file, name_version_architecture.deb, in which
+// the epoch separator is encoded as %3a.
+func debVersion(path string) string {
+	parts := strings.Split(strings.TrimSuffix(filepath.Base(path), ".deb"), "_")
+	if len(parts) < 2 {
+		return ""
+	}
+	return strings.ReplaceAll(parts[1], "%3a", ":")
+}
+
+// compareVersions compares two Debian package versions, [epoch:]upstream_version[-debian_revision], the
+// way dpkg does, and returns -1, 0 or 1.
+func compareVersions(a, b string) int {
+	aEpoch, aUpstream, aRevision := splitVersion(a)
+	bEpoch, bUpstream, bRevision := splitVersion(b)
+	switch {
+	case aEpoch < bEpoch:
+		return -1
+	case aEpoch > bEpoch:
+		return 1
+	}
+	if c := compareVersionPart(aUpstream, bUpstream); c != 0 {
+		return c
+	}
+	return compareVersionPart(aRevision, bRevision)
+}
+
+// splitVersion returns the epoch, the upstream version and the revision of a Debian package version.
+func splitVersion(v string) (int, string, string) {
+	epoch := 0
+	if i := strings.Index(v, ":"); i >= 0 {
+		epoch, _ = strconv.Atoi(v[:i])
+		v = v[i+1:]
+	}
+	revision := ""
+	if i := strings.LastIndex(v, "-"); i >= 0 {
+		v, revision = v[:i], v[i+1:]
+	}
+	return epoch, v, revision
+}
+
+// compareVersionPart compares upstream versions or revisions as alternating non-digit parts, compared
+// character by character, and digit parts, compared numerically.
+func compareVersionPart(a, b string) int {
+	for a != "" || b != "" {
+		var aText, bText string
+		aText, a = splitPrefix(a, false)
+		bText, b = splitPrefix(b, false)
+		if c := compareText(aText, bText); c != 0 {
+			return c
+		}
+		var aNum, bNum string
+		aNum, a = splitPrefix(a, true)
+		bNum, b = splitPrefix(b, true)
+		aNum, bNum = strings.TrimLeft(aNum, "0"), strings.TrimLeft(bNum, "0")
+		switch {
+		case len(aNum) != len(bNum):
+			if len(aNum) < len(bNum) {
+				return -1
+			}
+			return 1
+		case aNum != bNum:
+			if aNum < bNum {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+// splitPrefix splits the given string after its longest prefix of digits or, if digits is false, of non-digits.
+func splitPrefix(s string, digits bool) (string, string) {
+	i := 0
+	for i < len(s) && (s[i] >= '0' && s[i] <= '9') == digits {
+		i++
+	}
+	return s[:i], s[i:]
+}
+
+// compareText compares non-digit parts of versions, in which ~ sorts before anything, even the end of
+// the part, and letters sort before other characters.
+func compareText(a, b string) int {
+	for i := 0; i < len(a) || i < len(b); i++ {
+		var aOrder, bOrder int
+		if i < len(a) {
+			aOrder = charOrder(a[i])
+		}
+		if i < len(b) {
+			bOrder = charOrder(b[i])
+		}
+		switch {
+		case aOrder < bOrder:
+			return -1
+		case aOrder > bOrder:
+			return 1
+		}
+	}
+	return 0
+}
+
+// charOrder returns the sort weight of a character of a non-digit version part, as dpkg orders them.
+func charOrder(c byte) int {
+	switch {
+	case c == '~':
+		return -1
+	case c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z':
+		return int(c)
+	default:
+		return int(c) + 256
+	}
+}
+
+// downloadPackages downloads the packages and all of their dependencies from the mirror for the given
+// release, and returns the paths of the .deb files. Dependencies are resolved against an empty dpkg
+// status rather than the packages of the build image, which the run image may lack. apt-get keeps its
+// lists, status and archives in the given directory, so that it runs without root.
+func downloadPackages(ctx *gcp.Context, dir, release string, pkgs []string) []string {
+	state, archives := filepath.Join(dir, "state"), filepath.Join(dir, "cache")
+	ctx.MkdirAll(filepath.Join(state, "lists", "partial"), 0755)
+	ctx.MkdirAll(filepath.Join(archives, "archives", "partial"), 0755)
+
+	sources := filepath.Join(dir, "sources.list")
+	var sb strings.Builder
+	for _, suite := range []string{release, release + "-updates", release + "-security"} {
+		fmt.Fprintf(&sb, "deb %s %s main universe\n", mirror(), suite)
+	}
+	ctx.WriteFile(sources, []byte(sb.String()), 0644)
+	status := filepath.Join(dir, "status")
+	ctx.WriteFile(status, nil, 0644)
+
+	apt := []string{
+		"apt-get",
+		"-o", "Debug::NoLocking=1",
+		"-o", "Dir::State=" + state,
+		"-o", "Dir::State::status=" + status,
+		"-o", "Dir::Cache=" + archives,
+		"-o", "Dir::Etc::SourceList=" + sources,
+		"-o", "Dir::Etc::SourceParts=/dev/null",
+	}
+	ctx.Exec(append(apt, "--quiet", "update"), gcp.WithUserAttribution)
+	ctx.Exec(append(append(apt, "--quiet", "--yes", "--download-only", "--no-install-recommends", "install"), pkgs...), gcp.WithUserAttribution)
+	return ctx.Glob(filepath.Join(archives, "archives", "*.deb"))
+}
+
+// setEnvironment exposes the libraries, executables and pkg-config files of the installed packages
+// to the subsequent buildpacks and at run time, and their headers to compilers at build time.
+func setEnvironment(l *libcnb.Layer) {
+	var libs []string
+	for _, dir := range []string{"usr/lib", "lib"} {
+		if multiarch != "" {
+			libs = append(libs, filepath.Join(l.Path, dir, multiarch))
+		}
+		libs = append(libs, filepath.Join(l.Path, dir))
+	}
+	pkgConfig := []string{filepath.Join(l.Path, "usr/lib/pkgconfig"), filepath.Join(l.Path, "usr/share/pkgconfig")}
+	includes := []string{filepath.Join(l.Path, "usr/include")}
+	if multiarch != "" {
+		pkgConfig = append([]string{filepath.Join(l.Path, "usr/lib", multiarch, "pkgconfig")}, pkgConfig...)
+		includes = append(includes, filepath.Join(l.Path, "usr/include", multiarch))
+	}
+
+	sep := string(os.PathListSeparator)
+	l.SharedEnvironment.Prepend("PATH", sep, filepath.Join(l.Path, "usr/bin"))
+	l.SharedEnvironment.Prepend("LD_LIBRARY_PATH", sep, strings.Join(libs, sep))
+	l.SharedEnvironment.Prepend("PKG_CONFIG_PATH", sep, strings.Join(pkgConfig, sep))
+	l.BuildEnvironment.Prepend("LIBRARY_PATH", sep, strings.Join(libs, sep))
+	l.BuildEnvironment.Prepend("CPATH", sep, strings.Join(includes, sep))
+}
+
+// relocatePkgConfig points the pkg-config files of the installed packages to the layer, as they
+// declare the /usr prefix that the packages would be installed to by dpkg.
+func relocatePkgConfig(ctx *gcp.Context, root string) {
+	for _, pattern := range []string{"usr/lib/*/pkgconfig/*.pc", "usr/lib/pkgconfig/*.pc", "usr/share/pkgconfig/*.pc"} {
+		for _, pc := range ctx.Glob(filepath.Join(root, pattern)) {
+			content := string(ctx.ReadFile(pc))
+			ctx.WriteFile(pc, []byte(relocatePrefix(content, root)), 0644)
+		}
+	}
+}
+
+// relocatePrefix rewrites the /usr prefix variable of a pkg-config file to the given root.
+func relocatePrefix(content, root string) string {
+	lines := strings.Split(content, "\n")
+	for i, line := range lines {
+		if strings.HasPrefix(line, "prefix=/usr") {
+			lines[i] = "prefix=" + filepath.Join(root, "usr") + strings.TrimPrefix(line, "prefix=/usr")
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// mirror returns the Ubuntu archive mirror to download packages from.
+func mirror() string {
+	if m := strings.TrimSpace(os.Getenv(env.AptMirror)); m != "" {
+		return strings.TrimSuffix(m, "/")
+	}
+	return archMirror(runtime.GOARCH)
+}
+
+// archMirror returns the default Ubuntu archive mirror for the given Go architecture.
+func archMirror(arch string) string {
+	if arch == "amd64" || arch == "386" {
+		return defaultMirror
+	}
+	return portsMirror
+}
+
+// codename returns the release codename of the Ubuntu build image, e.g. `bionic`.
+func codename(ctx *gcp.Context) (string, error) {
+	m := codenameRegexp.FindStringSubmatch(string(ctx.ReadFile(osRelease)))
+	if m == nil {
+		return "", gcp.InternalErrorf("finding VERSION_CODENAME in %s", osRelease)
+	}
+	return m[1], nil
+}
@@ -0,0 +1,181 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	gcp "github.com/GoogleCloudPlatform/buildpacks/pkg/gcpbuildpack"
+)
+
+func TestDetect(t *testing.T) {
+	testCases := []struct {
+		name  string
+		files map[string]string
+		want  int
+	}{
+		{
+			name: "with Aptfile",
+			files: map[string]string{
+				"Aptfile": "libpq5",
+			},
+			want: 0,
+		},
+		{
+			name: "without Aptfile",
+			want: 100,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			gcp.TestDetect(t, detectFn, tc.name, tc.files, []string{}, tc.want)
+		})
+	}
+}
+
+func TestParseAptfile(t *testing.T) {
+	testCases := []struct {
+		name    string
+		content string
+		want    []string
+		wantErr bool
+	}{
+		{
+			name:    "names and versions",
+			content: "# Required by psycopg2.\nlibpq-dev\n\n  libxml2=2.9.4+dfsg1-6.1ubuntu1.5  \nlibstdc++6\n",
+			want:    []string{"libpq-dev", "libxml2=2.9.4+dfsg1-6.1ubuntu1.5", "libstdc++6"},
+		},
+		{
+			name:    "empty",
+			content: "# Nothing yet.\n",
+		},
+		{
+			name:    "several packages on a line",
+			content: "libpq-dev libxml2\n",
+			wantErr: true,
+		},
+		{
+			name:    "url",
+			content: "https://example.com/libfoo_1.0_amd64.deb\n",
+			wantErr: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := parseAptfile(tc.content)
+			if gotErr := err != nil; gotErr != tc.wantErr {
+				t.Fatalf("parseAptfile() got error: %v, want error: %t", err, tc.wantErr)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("parseAptfile() = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestLocalPackages(t *testing.T) {
+	d, err := ioutil.TempDir("", "test-local-packages-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(d)
+	for _, f := range []string{
+		"libpq5_10.9-0ubuntu0.18.04.1_amd64.deb",
+		"libpq5_10.20-0ubuntu0.18.04.1_amd64.deb",
+		"libpq5_10.20~rc1-0ubuntu0.18.04.1_amd64.deb",
+		"libpq-dev_10.19-0ubuntu0.18.04.1_amd64.deb",
+		"tzdata_2021a-0ubuntu0.18.04_all.deb",
+		"libxml2_1%3a2.9.4_amd64.deb",
+	} {
+		if err := ioutil.WriteFile(filepath.Join(d, f), nil, 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", f, err)
+		}
+	}
+
+	got, err := localPackages(d, []string{"libpq5", "libpq-dev", "tzdata", "libxml2=1:2.9.4"})
+	if err != nil {
+		t.Fatalf("localPackages() got error: %v", err)
+	}
+	want := []string{
+		filepath.Join(d, "libpq5_10.20-0ubuntu0.18.04.1_amd64.deb"),
+		filepath.Join(d, "libpq-dev_10.19-0ubuntu0.18.04.1_amd64.deb"),
+		filepath.Join(d, "tzdata_2021a-0ubuntu0.18.04_all.deb"),
+		filepath.Join(d, "libxml2_1%3a2.9.4_amd64.deb"),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("localPackages() = %q, want %q", got, want)
+	}
+
+	if _, err := localPackages(d, []string{"libpq5=9.6", "libssl1.1"}); err == nil {
+		t.Error("localPackages() with missing packages got no error, want error")
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	testCases := []struct {
+		a, b string
+		want int
+	}{
+		{a: "10.20-0ubuntu0.18.04.1", b: "10.9-0ubuntu0.18.04.1", want: 1},
+		{a: "1.0", b: "1.0", want: 0},
+		{a: "1.0", b: "1.00", want: 0},
+		{a: "1.0~rc1", b: "1.0", want: -1},
+		{a: "1.0~rc1", b: "1.0~rc1~beta", want: 1},
+		{a: "1.0a", b: "1.0", want: 1},
+		{a: "1.0a", b: "1.0+", want: -1},
+		{a: "1:1.0", b: "2.0", want: 1},
+		{a: "2.0-1", b: "2.0-1ubuntu1", want: -1},
+		{a: "2.0-1ubuntu1.2", b: "2.0-1ubuntu1.10", want: -1},
+		{a: "2021a-0ubuntu0.18.04", b: "2021e-0ubuntu0.18.04", want: -1},
+		{a: "1.2-3-4", b: "1.2-3-5", want: -1},
+	}
+	for _, tc := range testCases {
+		if got := compareVersions(tc.a, tc.b); got != tc.want {
+			t.Errorf("compareVersions(%q, %q) = %d, want %d", tc.a, tc.b, got, tc.want)
+		}
+		if got := compareVersions(tc.b, tc.a); got != -tc.want {
+			t.Errorf("compareVersions(%q, %q) = %d, want %d", tc.b, tc.a, got, -tc.want)
+		}
+	}
+}
+
+func TestRelocatePrefix(t *testing.T) {
+	content := "prefix=/usr\nlibdir=${prefix}/lib/x86_64-linux-gnu\n\nName: libpq\n"
+	want := "prefix=/layers/apt/usr\nlibdir=${prefix}/lib/x86_64-linux-gnu\n\nName: libpq\n"
+	if got := relocatePrefix(content, "/layers/apt"); got != want {
+		t.Errorf("relocatePrefix() = %q, want %q", got, want)
+	}
+}
+
+func TestArchMirror(t *testing.T) {
+	testCases := []struct {
+		arch string
+		want string
+	}{
+		{arch: "amd64", want: defaultMirror},
+		{arch: "386", want: defaultMirror},
+		{arch: "arm64", want: portsMirror},
+		{arch: "ppc64le", want: portsMirror},
+	}
+	for _, tc := range testCases {
+		if got := archMirror(tc.arch); got != tc.want {
+			t.Errorf("archMirror(%q) = %q, want %q", tc.arch, got, tc.want)
+		}
+	}
+}
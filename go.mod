@@ -8,6 +8,7 @@ require (
 	github.com/beevik/etree v1.1.0
 	github.com/blang/semver v3.5.2-0.20180723201105-3c1074078d32+incompatible
 	github.com/buildpacks/libcnb v1.25.4
+	github.com/google/go-cmp v0.5.5
 	github.com/onsi/gomega v1.19.0 // indirect
 	github.com/stretchr/objx v0.2.0 // indirect
 	gopkg.in/yaml.v3 v3.0.0-20210107192922-496545a6307b // indirect
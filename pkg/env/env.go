@@ -86,6 +86,34 @@ const (
 	// If this env var is not specified either, the hardcoded functions framework version will be used.
 	FunctionsFrameworkVersion = "FUNC_FRAMEWORK_VERSION"
 
+	// NPMStrictLockfile is an env var used to require an up-to-date lockfile for npm builds.
+	// When enabled, a missing lockfile or one that does not satisfy package.json is a user error
+	// instead of being regenerated.
+	// Example: `true`, `True`, `1` will enable strict lockfile mode.
+	NPMStrictLockfile = "FUNC_NPM_STRICT_LOCKFILE"
+
+	// NodeJSBundle is an env var used to bundle Node.js functions and their production dependencies
+	// into a single file with esbuild to reduce the size of the image.
+	// Example: `true`, `True`, `1` will enable bundling.
+	NodeJSBundle = "FUNC_NODEJS_BUNDLE"
+
+	// PipRequireHashes is an env var used to require that the application's Python requirements are pinned
+	// to exact versions with hashes, and to install them with `pip install --require-hashes`.
+	// Example: `true`, `True`, `1` will enable hash-checked installs.
+	PipRequireHashes = "FUNC_PIP_REQUIRE_HASHES"
+	// PipOnlyBinary is an env var used to restrict the installation of the application's Python requirements
+	// to wheels, passed to `pip install --only-binary`.
+	// Example: `:all:` to refuse all source distributions, `numpy,scipy` to refuse them for these packages.
+	PipOnlyBinary = "FUNC_PIP_ONLY_BINARY"
+	// PythonIsolatedVenv is an env var used to install Python dependencies in a virtual environment that
+	// includes its own pip and is isolated from the system site-packages, for every Python version.
+	// Example: `true`, `True`, `1` will enable the isolated virtual environment.
+	PythonIsolatedVenv = "FUNC_PYTHON_ISOLATED_VENV"
+	// StackPythonVersion is an env var set by the build images that ship their own Python interpreter.
+	// The python/runtime buildpack leaves that interpreter in place unless the application declares a version.
+	// Example: `3.9.5` in the py39 build image.
+	StackPythonVersion = "FUNC_STACK_PYTHON_VERSION"
+
 	// GoGCFlags is an env var used to pass through compilation flags to the Go compiler.
 	// Example: `-N -l` is used during debugging to disable optimizations and inlining.
 	GoGCFlags = "FUNC_GOGCFLAGS"
@@ -99,6 +127,28 @@ const (
 	// Example: `true`, `True`, `1` will enable development mode.
 	UseNativeImage = "FUNC_JAVA_USE_NATIVE_IMAGE"
 
+	// JavaDistribution is an env var used to select the distribution of the Java runtime to install.
+	// Example: `temurin` (default), `zulu`, `corretto` or `liberica`.
+	JavaDistribution = "FUNC_JAVA_DISTRIBUTION"
+	// JavaJDKDir is an env var used to install the Java runtime from the JDK and JRE tarballs in a local
+	// directory instead of downloading them, for offline builds.
+	// Example: `/workspace/jdks`.
+	JavaJDKDir = "FUNC_JAVA_JDK_DIR"
+
+	// JavaCDS is an env var used to create a class data sharing archive of the Java functions framework at
+	// build time, which the JVM maps to start the function faster.
+	// Example: `true`, `True`, `1` will enable the archive.
+	JavaCDS = "FUNC_JAVA_CDS"
+
+	// AptMirror is an env var used to specify the Ubuntu archive mirror from which the system packages
+	// listed in Aptfile are downloaded.
+	// Example: `http://mirrors.example.com/ubuntu`, defaults to `http://archive.ubuntu.com/ubuntu`.
+	AptMirror = "FUNC_APT_MIRROR"
+	// AptPackagesDir is an env var used to install the system packages listed in Aptfile from the .deb
+	// files in a local directory instead of a mirror, for offline builds.
+	// Example: `debs` for the debs directory of the application.
+	AptPackagesDir = "FUNC_APT_PACKAGES_DIR"
+
 	// LabelPrefix is a prefix for values that will be added to the final
 	// built user container. The prefix is stripped and the remainder forms the
 	// label key. For example, "GOOGLE_LABEL_ABC=Some-Value" will result in a
@@ -110,6 +160,14 @@ const (
 	MavenVersion = "MVN_VERSION"
 	// GradleVersion is the version of gradle. If not set, the maven version will be 7.4.2.
 	GradleVersion = "GRADLE_VERSION"
+	// MavenOffline is an env var used to run Maven offline, resolving dependencies and the Java functions
+	// framework only from the local repository, i.e. the cached m2 layer.
+	// Example: `true`, `True`, `1` will enable offline mode.
+	MavenOffline = "FUNC_MAVEN_OFFLINE"
+	// GradleBuildCache is an env var used to disable the Gradle build cache, which is on by default and kept
+	// in the cached Gradle user home so that task outputs are reused across builds.
+	// Example: `false`, `False`, `0` will disable the build cache.
+	GradleBuildCache = "FUNC_GRADLE_BUILD_CACHE"
 	// FunctionFrameworkJar is the path of function framework jar, can be a url or a local path.
 	FunctionFrameworkJar        = "FUNCTION_FRAMEWORK"
 	MavenRepository             = "MAVEN_REPOSITORY"
@@ -136,6 +194,44 @@ func IsUsingNativeImage() (bool, error) {
 	return isPresentAndTrue(UseNativeImage)
 }
 
+// IsJavaCDS returns true if a class data sharing archive of the Java functions framework should be created.
+func IsJavaCDS() (bool, error) {
+	return isPresentAndTrue(JavaCDS)
+}
+
+// IsMavenOffline returns true if Maven should resolve artifacts only from the local repository.
+func IsMavenOffline() (bool, error) {
+	return isPresentAndTrue(MavenOffline)
+}
+
+// IsGradleBuildCache returns true if Gradle builds should use the build cache, which is the default.
+func IsGradleBuildCache() (bool, error) {
+	if _, present := os.LookupEnv(GradleBuildCache); !present {
+		return true, nil
+	}
+	return isPresentAndTrue(GradleBuildCache)
+}
+
+// IsNPMStrictLockfile returns true if npm builds must use an existing, up-to-date lockfile.
+func IsNPMStrictLockfile() (bool, error) {
+	return isPresentAndTrue(NPMStrictLockfile)
+}
+
+// IsNodeJSBundle returns true if Node.js functions should be bundled with esbuild.
+func IsNodeJSBundle() (bool, error) {
+	return isPresentAndTrue(NodeJSBundle)
+}
+
+// IsPipRequireHashes returns true if the application's Python requirements must be pinned with hashes.
+func IsPipRequireHashes() (bool, error) {
+	return isPresentAndTrue(PipRequireHashes)
+}
+
+// IsPythonIsolatedVenv returns true if Python dependencies should be installed in an isolated virtual environment.
+func IsPythonIsolatedVenv() (bool, error) {
+	return isPresentAndTrue(PythonIsolatedVenv)
+}
+
 // Returns true if the environment variable evaluates to True.
 func isPresentAndTrue(varName string) (bool, error) {
 	varValue, present := os.LookupEnv(varName)
@@ -90,3 +90,62 @@ func TestIsDebugMode(t *testing.T) {
 		})
 	}
 }
+
+func TestIsGradleBuildCache(t *testing.T) {
+	testCases := []struct {
+		name    string
+		notSet  bool
+		value   string
+		wantErr bool
+		want    bool
+	}{
+		{
+			name:   "not set",
+			notSet: true,
+			want:   true,
+		},
+		{
+			name:    "set to bad value",
+			value:   "not a bool",
+			wantErr: true,
+		},
+		{
+			name:  "set to true",
+			value: "true",
+			want:  true,
+		},
+		{
+			name:  "set to false",
+			value: "false",
+			want:  false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.notSet {
+				if err := os.Unsetenv(GradleBuildCache); err != nil {
+					t.Fatalf("Failed to unset env: %v", err)
+				}
+			} else {
+				if err := os.Setenv(GradleBuildCache, tc.value); err != nil {
+					t.Fatalf("Failed to set env: %v", err)
+				}
+				defer func() {
+					if err := os.Unsetenv(GradleBuildCache); err != nil {
+						t.Fatalf("Failed to unset env: %v", err)
+					}
+				}()
+			}
+
+			got, err := IsGradleBuildCache()
+
+			if err != nil != tc.wantErr {
+				t.Fatalf("got err=%t, want err=%t: %v", err != nil, tc.wantErr, err)
+			}
+			if got != tc.want {
+				t.Errorf("IsGradleBuildCache=%t, want=%t", got, tc.want)
+			}
+		})
+	}
+}
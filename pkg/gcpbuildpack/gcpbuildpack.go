@@ -161,6 +161,11 @@ func (ctx *Context) Processes() []libcnb.Process {
 	return ctx.buildResult.Processes
 }
 
+// Bindings returns the service bindings provided by the platform for the build.
+func (ctx *Context) Bindings() libcnb.Bindings {
+	return ctx.buildContext.Platform.Bindings
+}
+
 // Main is the main entrypoint to a buildpack's detect and build functions.
 func Main(d DetectFn, b BuildFn) {
 	switch filepath.Base(os.Args[0]) {
@@ -20,11 +20,13 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"os"
 	"path/filepath"
 	"regexp"
 	"strings"
 	"time"
 
+	"github.com/GoogleCloudPlatform/buildpacks/pkg/env"
 	gcp "github.com/GoogleCloudPlatform/buildpacks/pkg/gcpbuildpack"
 	"github.com/buildpacks/libcnb"
 )
@@ -38,6 +40,10 @@ const (
 	ManifestPath = "META-INF/MANIFEST.MF"
 
 	expiryTimestampKey = "expiry_timestamp"
+
+	// LaunchHomeEnv is the build time env var that holds the Java home of the runtime that launches the
+	// application, which is a JRE that is not on the PATH of the build if one is installed.
+	LaunchHomeEnv = "JAVA_LAUNCH_HOME"
 )
 
 var (
@@ -121,7 +127,8 @@ func MainFromManifest(ctx *gcp.Context, manifestPath string) (string, error) {
 	return "", gcp.UserErrorf("no Main-Class manifest entry found in %s", manifestPath)
 }
 
-// CheckCacheExpiration clears the m2 layer and sets a new expiry timestamp when the cache is past expiration.
+// CheckCacheExpiration clears a dependency cache layer, such as the m2 or Gradle user home layer, and sets a new
+// expiry timestamp when the cache is past expiration.
 func CheckCacheExpiration(ctx *gcp.Context, m2CachedRepo *libcnb.Layer) {
 	t := time.Now()
 	expiry := ctx.GetMetadata(m2CachedRepo, expiryTimestampKey)
@@ -140,3 +147,18 @@ func CheckCacheExpiration(ctx *gcp.Context, m2CachedRepo *libcnb.Layer) {
 	ctx.ClearLayer(m2CachedRepo)
 	ctx.SetMetadata(m2CachedRepo, expiryTimestampKey, time.Now().Add(repoExpiration).Format(dateFormat))
 }
+
+// MavenModule returns the module of a multi-module Maven project that FUNC_BUILDABLE selects, in a form that
+// `mvn --projects` accepts: a directory relative to the application root, e.g. `functions/hello`, or
+// `[groupId]:artifactId`. It returns an empty string if no module is selected.
+func MavenModule() string {
+	buildable := strings.TrimSpace(os.Getenv(env.Buildable))
+	if buildable == "" || strings.Contains(buildable, ":") {
+		return buildable
+	}
+	module := filepath.ToSlash(filepath.Clean(buildable))
+	if module == "." {
+		return ""
+	}
+	return module
+}
@@ -16,11 +16,13 @@ package java
 
 import (
 	"io/ioutil"
+	"os"
 	"path/filepath"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/GoogleCloudPlatform/buildpacks/pkg/env"
 	gcp "github.com/GoogleCloudPlatform/buildpacks/pkg/gcpbuildpack"
 	"github.com/buildpacks/libcnb"
 )
@@ -268,3 +270,32 @@ func setupTestManifest(t *testing.T, mfContent []byte) string {
 	}
 	return mfPath
 }
+
+func TestMavenModule(t *testing.T) {
+	testCases := []struct {
+		buildable string
+		want      string
+	}{
+		{buildable: "", want: ""},
+		{buildable: ".", want: ""},
+		{buildable: "./functions/hello/", want: "functions/hello"},
+		{buildable: "com.example:hello", want: "com.example:hello"},
+		{buildable: ":hello", want: ":hello"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.buildable, func(t *testing.T) {
+			if err := os.Setenv(env.Buildable, tc.buildable); err != nil {
+				t.Fatalf("Failed to set env: %v", err)
+			}
+			defer func() {
+				if err := os.Unsetenv(env.Buildable); err != nil {
+					t.Fatalf("Failed to unset env: %v", err)
+				}
+			}()
+
+			if got := MavenModule(); got != tc.want {
+				t.Errorf("MavenModule() = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,258 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nodejs
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	gcp "github.com/GoogleCloudPlatform/buildpacks/pkg/gcpbuildpack"
+	"github.com/buildpacks/libcnb"
+)
+
+const (
+	esbuildLayer   = "esbuild"
+	esbuildVersion = "0.15.13"
+	// BundleFile is the name of the file that the function is bundled into.
+	BundleFile = "index.js"
+
+	versionKey = "version"
+)
+
+// errNativeAddon stops the walk of a package directory once a native addon is found.
+var errNativeAddon = errors.New("native addon found")
+
+// BundleFunction uses esbuild to bundle the function entry file and the production dependencies it
+// requires from node_modules into a single file in the given layer. Packages with native addons and the
+// given external packages are left out of the bundle; node_modules is pruned to those packages and their
+// dependencies, which must be resolvable from node_modules at run time.
+func BundleFunction(ctx *gcp.Context, entry string, l *libcnb.Layer, external []string) error {
+	before, err := dirSize("node_modules")
+	if err != nil {
+		return gcp.InternalErrorf("computing size of node_modules: %v", err)
+	}
+	native, err := NativeAddonPackages("node_modules")
+	if err != nil {
+		return gcp.InternalErrorf("finding native addons in node_modules: %v", err)
+	}
+	if len(native) > 0 {
+		ctx.Logf("Keeping packages with native addons external to the bundle: %s", strings.Join(native, ", "))
+	}
+	external = append(native, external...)
+
+	esbuild := installESBuild(ctx)
+	ctx.ClearLayer(l)
+	bundle := filepath.Join(l.Path, BundleFile)
+	target := "node" + strings.TrimPrefix(strings.TrimSpace(NodeVersion(ctx)), "v")
+	cmd := []string{esbuild, entry, "--bundle", "--platform=node", "--target=" + target, "--log-level=warning", "--outfile=" + bundle}
+	for _, e := range external {
+		cmd = append(cmd, "--external:"+e)
+	}
+	ctx.Exec(cmd, gcp.WithUserAttribution)
+
+	if err := PruneNodeModules(ctx, "node_modules", external); err != nil {
+		return gcp.InternalErrorf("pruning node_modules: %v", err)
+	}
+	after, err := dirSize("node_modules")
+	if err != nil {
+		return gcp.InternalErrorf("computing size of node_modules: %v", err)
+	}
+	fi, err := os.Stat(bundle)
+	if err != nil {
+		return gcp.InternalErrorf("stat %s: %v", bundle, err)
+	}
+	after += fi.Size()
+	ctx.Logf("Bundled function into %s: %d bytes of node_modules before, %d bytes of bundle and external packages after, %d bytes saved.", bundle, before, after, before-after)
+	return nil
+}
+
+// installESBuild installs esbuild into a cached build layer and returns the path to its executable.
+func installESBuild(ctx *gcp.Context) string {
+	l := ctx.Layer(esbuildLayer, gcp.BuildLayer, gcp.CacheLayer)
+	esbuild := filepath.Join(l.Path, "node_modules", ".bin", "esbuild")
+
+	if ctx.GetMetadata(l, versionKey) == esbuildVersion && ctx.FileExists(esbuild) {
+		ctx.CacheHit(esbuildLayer)
+		ctx.Logf("esbuild v%s cache hit, skipping installation.", esbuildVersion)
+		return esbuild
+	}
+	ctx.CacheMiss(esbuildLayer)
+	ctx.ClearLayer(l)
+	ctx.Logf("Installing esbuild v%s", esbuildVersion)
+	ctx.Exec([]string{"npm", "install", "--quiet", "--no-save", "--prefix", l.Path, "esbuild@" + esbuildVersion}, gcp.WithUserAttribution)
+	ctx.SetMetadata(l, versionKey, esbuildVersion)
+	return esbuild
+}
+
+// NativeAddonPackages returns the names of the packages installed in the given node_modules directory
+// that contain native addons, which cannot be bundled. A package contains a native addon if it, or any
+// of its nested dependencies, has a binding.gyp or a compiled .node file.
+func NativeAddonPackages(nodeModules string) ([]string, error) {
+	pkgs, err := installedPackages(nodeModules)
+	if err != nil {
+		return nil, err
+	}
+	var native []string
+	for _, pkg := range pkgs {
+		found := false
+		err := filepath.Walk(filepath.Join(nodeModules, pkg), func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !info.IsDir() && (info.Name() == "binding.gyp" || strings.HasSuffix(info.Name(), ".node")) {
+				found = true
+				return errNativeAddon
+			}
+			return nil
+		})
+		if err != nil && err != errNativeAddon {
+			return nil, err
+		}
+		if found {
+			native = append(native, pkg)
+		}
+	}
+	return native, nil
+}
+
+// PruneNodeModules removes every package from the given node_modules directory except the given
+// packages and their transitive dependencies.
+func PruneNodeModules(ctx *gcp.Context, nodeModules string, keep []string) error {
+	closure, err := dependencyClosure(nodeModules, keep)
+	if err != nil {
+		return err
+	}
+	pkgs, err := installedPackages(nodeModules)
+	if err != nil {
+		return err
+	}
+	for _, pkg := range pkgs {
+		if !closure[pkg] {
+			ctx.RemoveAll(filepath.Join(nodeModules, pkg))
+		}
+	}
+	// Remove scope directories left empty.
+	for _, fi := range ctx.ReadDir(nodeModules) {
+		if dir := filepath.Join(nodeModules, fi.Name()); fi.IsDir() && strings.HasPrefix(fi.Name(), "@") && len(ctx.ReadDir(dir)) == 0 {
+			ctx.RemoveAll(dir)
+		}
+	}
+	return nil
+}
+
+// installedPackages returns the names of the top-level packages in the given node_modules directory,
+// including scoped packages such as `@scope/name`. A missing directory has no packages.
+func installedPackages(nodeModules string) ([]string, error) {
+	entries, err := ioutil.ReadDir(nodeModules)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var pkgs []string
+	for _, e := range entries {
+		name := e.Name()
+		if !e.IsDir() || strings.HasPrefix(name, ".") {
+			continue
+		}
+		if !strings.HasPrefix(name, "@") {
+			pkgs = append(pkgs, name)
+			continue
+		}
+		scoped, err := ioutil.ReadDir(filepath.Join(nodeModules, name))
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range scoped {
+			if s.IsDir() {
+				pkgs = append(pkgs, name+"/"+s.Name())
+			}
+		}
+	}
+	sort.Strings(pkgs)
+	return pkgs, nil
+}
+
+// dependencyClosure returns the given packages and the dependencies they resolve from the top level
+// of the given node_modules directory. Dependencies nested in a package's own node_modules are part of
+// the package directory and are not listed.
+func dependencyClosure(nodeModules string, pkgs []string) (map[string]bool, error) {
+	closure := map[string]bool{}
+	queue := append([]string{}, pkgs...)
+	for len(queue) > 0 {
+		pkg := queue[0]
+		queue = queue[1:]
+		if closure[pkg] {
+			continue
+		}
+		dir := filepath.Join(nodeModules, pkg)
+		if _, err := os.Stat(dir); os.IsNotExist(err) {
+			continue
+		}
+		closure[pkg] = true
+
+		raw, err := ioutil.ReadFile(filepath.Join(dir, "package.json"))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		var pjs struct {
+			Dependencies         map[string]string `json:"dependencies"`
+			OptionalDependencies map[string]string `json:"optionalDependencies"`
+		}
+		if err := json.Unmarshal(raw, &pjs); err != nil {
+			return nil, fmt.Errorf("unmarshalling package.json of %s: %v", pkg, err)
+		}
+		for dep := range pjs.Dependencies {
+			if _, err := os.Stat(filepath.Join(dir, "node_modules", dep)); os.IsNotExist(err) {
+				queue = append(queue, dep)
+			}
+		}
+		for dep := range pjs.OptionalDependencies {
+			if _, err := os.Stat(filepath.Join(dir, "node_modules", dep)); os.IsNotExist(err) {
+				queue = append(queue, dep)
+			}
+		}
+	}
+	return closure, nil
+}
+
+// dirSize returns the total size in bytes of the regular files under the given directory.
+// A missing directory has a size of zero.
+func dirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == dir {
+				return nil
+			}
+			return err
+		}
+		if info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
@@ -0,0 +1,110 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nodejs
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	gcp "github.com/GoogleCloudPlatform/buildpacks/pkg/gcpbuildpack"
+	"github.com/buildpacks/libcnb"
+)
+
+const (
+	corepackLayer = "corepack"
+	// packageManagerKey is the layer metadata key of the `packageManager` value the layer was prepared for.
+	packageManagerKey = "package_manager"
+
+	// YarnNodeLinkerEnv sets how Yarn berry installs dependencies, overriding nodeLinker in .yarnrc.yml.
+	YarnNodeLinkerEnv = "YARN_NODE_LINKER"
+)
+
+// YarnBerry returns true if the given Yarn version is Yarn 2 or later, known as "berry".
+func YarnBerry(version string) bool {
+	return !strings.HasPrefix(version, "1.")
+}
+
+// PackageManager returns the name and version of the package manager declared in the `packageManager`
+// field of package.json, e.g. `yarn` and `3.2.0` for `yarn@3.2.0+sha224.953c8233`. Both are empty if
+// no package manager is declared.
+func PackageManager(pjs *PackageJSON) (string, string, error) {
+	if pjs.PackageManager == "" {
+		return "", "", nil
+	}
+	parts := strings.SplitN(pjs.PackageManager, "@", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", gcp.UserErrorf("invalid packageManager %q in package.json, expected <name>@<version>", pjs.PackageManager)
+	}
+	// Drop the optional integrity hash.
+	version := strings.SplitN(parts[1], "+", 2)[0]
+	return parts[0], version, nil
+}
+
+// ActivatePackageManager uses Corepack to activate the exact version of the package manager declared
+// in the `packageManager` field of package.json. It returns false, without activating anything, when
+// package.json declares no package manager or one other than want, or when Corepack is not available.
+// The package manager is cached in a layer keyed by the declared value, and also available at launch.
+func ActivatePackageManager(ctx *gcp.Context, pjs *PackageJSON, want string) (bool, error) {
+	name, version, err := PackageManager(pjs)
+	if err != nil {
+		return false, err
+	}
+	if name == "" {
+		return false, nil
+	}
+	if name != want {
+		ctx.Debugf("package.json declares package manager %q, not activating %s.", name, want)
+		return false, nil
+	}
+	if result := ctx.Exec([]string{"bash", "-c", "command -v corepack || true"}); result.Stdout == "" {
+		ctx.Warnf("packageManager %q in package.json requires Corepack, which ships with Node.js 16.9.0 and later. Using the default %s.", pjs.PackageManager, want)
+		return false, nil
+	}
+
+	l := ctx.Layer(corepackLayer, gcp.BuildLayer, gcp.CacheLayer, gcp.LaunchLayer)
+	bin := filepath.Join(l.Path, "bin")
+	home := filepath.Join(l.Path, "home")
+
+	if ctx.GetMetadata(l, packageManagerKey) == pjs.PackageManager {
+		ctx.CacheHit(corepackLayer)
+		ctx.Logf("%s v%s cache hit, skipping installation.", name, version)
+	} else {
+		ctx.CacheMiss(corepackLayer)
+		ctx.ClearLayer(l)
+		ctx.MkdirAll(bin, 0755)
+		ctx.Logf("Installing %s v%s with Corepack", name, version)
+		ctx.Exec([]string{"corepack", "enable", "--install-directory", bin, name}, gcp.WithUserAttribution)
+		ctx.Exec([]string{"corepack", "prepare", pjs.PackageManager, "--activate"}, gcp.WithEnv("COREPACK_HOME="+home), gcp.WithUserAttribution)
+	}
+
+	ctx.SetMetadata(l, packageManagerKey, pjs.PackageManager)
+	// Corepack resolves the package manager from COREPACK_HOME without a download at launch.
+	l.SharedEnvironment.Override("COREPACK_HOME", home)
+	ctx.Setenv("COREPACK_HOME", home)
+	ctx.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	if name == "yarn" && YarnBerry(version) {
+		// Plug'n'Play, the default of Yarn berry, leaves no node_modules for the buildpacks and the
+		// functions framework. The override also applies to `yarn run` at launch.
+		ctx.Logf("Installing dependencies into node_modules with Yarn v%s, Plug'n'Play is not supported.", version)
+		l.SharedEnvironment.Override(YarnNodeLinkerEnv, "node-modules")
+		ctx.Setenv(YarnNodeLinkerEnv, "node-modules")
+	}
+	ctx.AddBOMEntry(libcnb.BOMEntry{
+		Name:     name,
+		Metadata: map[string]interface{}{"version": version, "provisioner": corepackLayer},
+	})
+	return true, nil
+}
@@ -0,0 +1,94 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nodejs
+
+import (
+	"path/filepath"
+	"strings"
+
+	gcp "github.com/GoogleCloudPlatform/buildpacks/pkg/gcpbuildpack"
+	"github.com/buildpacks/libcnb"
+)
+
+const (
+	nodeGypError = "gyp ERR!"
+)
+
+// nodeGypTools maps the build tools required by node-gyp to output that indicates they are missing.
+var nodeGypTools = []struct {
+	name     string
+	patterns []string
+}{
+	{"python3", []string{"find Python", "Can't find Python executable", "Could not find any Python installation"}},
+	{"make", []string{"not found: make", "make: not found", "make: command not found"}},
+	{"g++", []string{"not found: g++", "g++: not found", "g++: command not found", "spawn g++ ENOENT"}},
+}
+
+// NodeABI returns the ABI version of the installed Node.js, which native addons are compiled against.
+func NodeABI(ctx *gcp.Context) string {
+	result := ctx.Exec([]string{"node", "-p", "process.versions.modules"})
+	return result.Stdout
+}
+
+// RebuildNativeAddons recompiles the native addons in node_modules if the cached dependencies in the
+// layer were installed with a Node.js of a different ABI version, e.g. after a Node.js version bump.
+// The rebuilt node_modules replaces the one cached in the layer. The given options are applied to the
+// rebuild command.
+func RebuildNativeAddons(ctx *gcp.Context, l *libcnb.Layer, opts ...gcp.ExecOption) {
+	currentABI := NodeABI(ctx)
+	metaABI := ctx.GetMetadata(l, nodeABIKey)
+	if currentABI == metaABI {
+		return
+	}
+
+	ctx.Logf("Node.js ABI version changed from %q to %q, rebuilding native addons.", metaABI, currentABI)
+	ctx.Exec([]string{"npm", "rebuild"}, append([]gcp.ExecOption{WithNativeAddonTips(ctx), gcp.WithUserAttribution}, opts...)...)
+
+	nm := filepath.Join(l.Path, "node_modules")
+	ctx.RemoveAll(nm)
+	ctx.Exec([]string{"cp", "--archive", "node_modules", nm}, gcp.WithUserTimingAttribution)
+	ctx.SetMetadata(l, nodeABIKey, currentABI)
+	ctx.SetMetadata(l, nodeVersionKey, NodeVersion(ctx))
+}
+
+// WithNativeAddonTips prints a tip when a command fails because node-gyp could not find the tools it
+// needs to compile native addons, and keeps the tail of the combined output for the error message.
+func WithNativeAddonTips(ctx *gcp.Context) gcp.ExecOption {
+	return gcp.WithMessageProducer(func(result *gcp.ExecResult) string {
+		if result.ExitCode != 0 {
+			if missing := missingBuildTools(result.Combined); len(missing) > 0 {
+				ctx.Tipf("Tip: native addons are compiled with node-gyp, which requires %s in the build image. Install them in the build image or use dependencies that ship prebuilt binaries.", strings.Join(missing, ", "))
+			}
+		}
+		return gcp.KeepCombinedTail(result)
+	})
+}
+
+// missingBuildTools returns the node-gyp build tools that the given output reports as missing.
+func missingBuildTools(output string) []string {
+	if !strings.Contains(output, nodeGypError) {
+		return nil
+	}
+	var missing []string
+	for _, tool := range nodeGypTools {
+		for _, p := range tool.patterns {
+			if strings.Contains(output, p) {
+				missing = append(missing, tool.name)
+				break
+			}
+		}
+	}
+	return missing
+}
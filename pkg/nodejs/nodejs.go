@@ -34,6 +34,7 @@ const (
 	EnvProduction = "production"
 
 	nodeVersionKey    = "node_version"
+	nodeABIKey        = "node_abi"
 	dependencyHashKey = "dependency_hash"
 )
 
@@ -41,6 +42,10 @@ type packageEnginesJSON struct {
 	Node string `json:"node"`
 }
 
+type packageVoltaJSON struct {
+	Node string `json:"node"`
+}
+
 type packageScriptsJSON struct {
 	Start    string `json:"start"`
 	GCPBuild string `json:"gcp-build"`
@@ -51,9 +56,11 @@ type PackageJSON struct {
 	Main            string             `json:"main"`
 	Version         string             `json:"version"`
 	Engines         packageEnginesJSON `json:"engines"`
+	Volta           packageVoltaJSON   `json:"volta"`
 	Scripts         packageScriptsJSON `json:"scripts"`
 	Dependencies    map[string]string  `json:"dependencies"`
 	DevDependencies map[string]string  `json:"devDependencies"`
+	PackageManager  string             `json:"packageManager"`
 }
 
 // ReadPackageJSON returns deserialized package.json from the given dir. Empty dir uses the current working directory.
@@ -86,9 +93,9 @@ func NodeEnv() string {
 }
 
 // CheckCache checks whether cached dependencies exist and match.
+// The Node.js version is not part of the dependency hash: cached dependencies remain usable across
+// Node.js versions, see RebuildNativeAddons for the native addons that need to be recompiled.
 func CheckCache(ctx *gcp.Context, l *libcnb.Layer, opts ...cache.Option) (bool, error) {
-	currentNodeVersion := NodeVersion(ctx)
-	opts = append(opts, cache.WithStrings(currentNodeVersion))
 	currentDependencyHash, err := cache.Hash(ctx, opts...)
 	if err != nil {
 		return false, fmt.Errorf("computing dependency hash: %v", err)
@@ -110,7 +117,8 @@ func CheckCache(ctx *gcp.Context, l *libcnb.Layer, opts ...cache.Option) (bool,
 
 	// Update the layer metadata.
 	ctx.SetMetadata(l, dependencyHashKey, currentDependencyHash)
-	ctx.SetMetadata(l, nodeVersionKey, currentNodeVersion)
+	ctx.SetMetadata(l, nodeVersionKey, NodeVersion(ctx))
+	ctx.SetMetadata(l, nodeABIKey, NodeABI(ctx))
 
 	return false, nil
 }
@@ -21,6 +21,8 @@ import (
 	"reflect"
 	"strings"
 	"testing"
+
+	"github.com/buildpacks/libcnb"
 )
 
 func TestReadPackageJSON(t *testing.T) {
@@ -75,3 +77,415 @@ func TestReadPackageJSON(t *testing.T) {
 		t.Errorf("ReadPackageJSON\ngot %#v\nwant %#v", *got, want)
 	}
 }
+
+func TestDirSize(t *testing.T) {
+	d, err := ioutil.TempDir("/tmp", "test-dir-size-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(d)
+
+	files := map[string]string{
+		"a.js":            "12345",
+		"pkg/index.js":    "123",
+		"pkg/lib/util.js": "1234567",
+	}
+	for f, c := range files {
+		fn := filepath.Join(d, f)
+		if err := os.MkdirAll(filepath.Dir(fn), 0755); err != nil {
+			t.Fatalf("Failed to create dir for %s: %v", f, err)
+		}
+		if err := ioutil.WriteFile(fn, []byte(c), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", f, err)
+		}
+	}
+
+	got, err := dirSize(d)
+	if err != nil {
+		t.Fatalf("dirSize(%q) got error: %v", d, err)
+	}
+	if got != 15 {
+		t.Errorf("dirSize(%q) = %d, want 15", d, got)
+	}
+
+	got, err = dirSize(filepath.Join(d, "missing"))
+	if err != nil {
+		t.Fatalf("dirSize() of missing dir got error: %v", err)
+	}
+	if got != 0 {
+		t.Errorf("dirSize() of missing dir = %d, want 0", got)
+	}
+}
+
+func TestNPMRCContents(t *testing.T) {
+	testCases := []struct {
+		name     string
+		bindings libcnb.Bindings
+		want     string
+	}{
+		{
+			name: "no bindings",
+			want: "",
+		},
+		{
+			name: "other binding types",
+			bindings: libcnb.Bindings{
+				{Name: "maven", Type: "maven", Secret: map[string]string{"settings.xml": "<settings/>"}},
+			},
+			want: "",
+		},
+		{
+			name: "registry with token",
+			bindings: libcnb.Bindings{
+				{Name: "npm", Type: "npmrc", Secret: map[string]string{"registry": "https://npm.example.com", "token": "secret"}},
+			},
+			want: "registry=https://npm.example.com/\n//npm.example.com/:_authToken=secret\n",
+		},
+		{
+			name: "scoped registry without token",
+			bindings: libcnb.Bindings{
+				{Name: "npm", Type: "npmrc", Secret: map[string]string{"registry": "https://npm.example.com/repo/", "scope": "my-org"}},
+			},
+			want: "@my-org:registry=https://npm.example.com/repo/\n",
+		},
+		{
+			name: "npmrc file",
+			bindings: libcnb.Bindings{
+				{Name: "npm", Type: "npmrc", Secret: map[string]string{".npmrc": "always-auth=true"}},
+				{Name: "scoped", Type: "npmrc", Secret: map[string]string{"registry": "https://a.example.com", "scope": "@a"}},
+			},
+			want: "always-auth=true\n@a:registry=https://a.example.com/\n",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := npmrcContents(tc.bindings)
+			if err != nil {
+				t.Fatalf("npmrcContents() got error: %v", err)
+			}
+			if got != tc.want {
+				t.Errorf("npmrcContents() = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestNPMRCContentsFail(t *testing.T) {
+	testCases := []struct {
+		name   string
+		secret map[string]string
+	}{
+		{
+			name:   "missing registry",
+			secret: map[string]string{"token": "secret"},
+		},
+		{
+			name:   "registry without scheme",
+			secret: map[string]string{"registry": "npm.example.com", "token": "secret"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			bindings := libcnb.Bindings{{Name: "npm", Type: "npmrc", Secret: tc.secret}}
+			if _, err := npmrcContents(bindings); err == nil {
+				t.Errorf("npmrcContents() did not return error")
+			}
+		})
+	}
+}
+
+func TestYarnRegistryEnv(t *testing.T) {
+	testCases := []struct {
+		name     string
+		bindings libcnb.Bindings
+		want     []string
+	}{
+		{
+			name: "no bindings",
+		},
+		{
+			name: "registry with token",
+			bindings: libcnb.Bindings{
+				{Name: "npm", Type: "npmrc", Secret: map[string]string{"registry": "https://npm.example.com/", "token": "secret"}},
+			},
+			want: []string{"YARN_NPM_REGISTRY_SERVER=https://npm.example.com", "YARN_NPM_AUTH_TOKEN=secret"},
+		},
+		{
+			name: "scoped registry and npmrc file",
+			bindings: libcnb.Bindings{
+				{Name: "scoped", Type: "npmrc", Secret: map[string]string{"registry": "https://a.example.com", "scope": "@a", "token": "secret"}},
+				{Name: "npm", Type: "npmrc", Secret: map[string]string{".npmrc": "registry=https://npm.example.com/"}},
+			},
+		},
+		{
+			name: "last registry without token",
+			bindings: libcnb.Bindings{
+				{Name: "a", Type: "npmrc", Secret: map[string]string{"registry": "https://a.example.com", "token": "secret"}},
+				{Name: "b", Type: "npmrc", Secret: map[string]string{"registry": "https://b.example.com"}},
+			},
+			want: []string{"YARN_NPM_REGISTRY_SERVER=https://b.example.com"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := yarnRegistryEnv(tc.bindings); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("yarnRegistryEnv() = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestMissingBuildTools(t *testing.T) {
+	testCases := []struct {
+		name   string
+		output string
+		want   []string
+	}{
+		{
+			name:   "no node-gyp error",
+			output: "npm ERR! make: not found",
+		},
+		{
+			name:   "node-gyp error without missing tools",
+			output: "gyp ERR! build error\ngyp ERR! stack Error: `make` failed with exit code: 2",
+		},
+		{
+			name:   "missing python",
+			output: "gyp ERR! find Python\ngyp ERR! find Python Python is not set from command line or npm configuration",
+			want:   []string{"python3"},
+		},
+		{
+			name:   "missing make and g++",
+			output: "gyp ERR! build error\ngyp ERR! stack Error: not found: make\nmake: g++: not found",
+			want:   []string{"make", "g++"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := missingBuildTools(tc.output)
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("missingBuildTools() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestPackageManager(t *testing.T) {
+	testCases := []struct {
+		name           string
+		packageManager string
+		wantName       string
+		wantVersion    string
+	}{
+		{
+			name: "not declared",
+		},
+		{
+			name:           "yarn",
+			packageManager: "yarn@3.2.0",
+			wantName:       "yarn",
+			wantVersion:    "3.2.0",
+		},
+		{
+			name:           "npm with integrity hash",
+			packageManager: "npm@8.19.2+sha224.953c8233f7a92884eee2de69a1b92d1f2ec1655e66d08071ba9a02fa",
+			wantName:       "npm",
+			wantVersion:    "8.19.2",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			gotName, gotVersion, err := PackageManager(&PackageJSON{PackageManager: tc.packageManager})
+			if err != nil {
+				t.Fatalf("PackageManager(%q) got error: %v", tc.packageManager, err)
+			}
+			if gotName != tc.wantName || gotVersion != tc.wantVersion {
+				t.Errorf("PackageManager(%q) = (%q, %q), want (%q, %q)", tc.packageManager, gotName, gotVersion, tc.wantName, tc.wantVersion)
+			}
+		})
+	}
+}
+
+func TestPackageManagerFail(t *testing.T) {
+	for _, pm := range []string{"yarn", "@3.2.0", "yarn@"} {
+		t.Run(pm, func(t *testing.T) {
+			if _, _, err := PackageManager(&PackageJSON{PackageManager: pm}); err == nil {
+				t.Errorf("PackageManager(%q) did not return error", pm)
+			}
+		})
+	}
+}
+
+func TestYarnBerry(t *testing.T) {
+	testCases := []struct {
+		version string
+		want    bool
+	}{
+		{version: "1.22.19", want: false},
+		{version: "2.4.3", want: true},
+		{version: "3.2.0", want: true},
+		{version: "4.0.0-rc.1", want: true},
+	}
+	for _, tc := range testCases {
+		if got := YarnBerry(tc.version); got != tc.want {
+			t.Errorf("YarnBerry(%q) = %t, want %t", tc.version, got, tc.want)
+		}
+	}
+}
+
+func TestSatisfies(t *testing.T) {
+	testCases := []struct {
+		version       string
+		rng           string
+		want          bool
+		wantCheckable bool
+	}{
+		{version: "4.17.1", rng: "4.17.1", want: true, wantCheckable: true},
+		{version: "4.0.0", rng: "4.17.1", want: false, wantCheckable: true},
+		{version: "4.18.2", rng: "^4.17.1", want: true, wantCheckable: true},
+		{version: "5.0.0", rng: "^4.17.1", want: false, wantCheckable: true},
+		{version: "0.2.5", rng: "^0.2.3", want: true, wantCheckable: true},
+		{version: "0.3.0", rng: "^0.2.3", want: false, wantCheckable: true},
+		{version: "1.2.9", rng: "~1.2.3", want: true, wantCheckable: true},
+		{version: "1.3.0", rng: "~1.2.3", want: false, wantCheckable: true},
+		{version: "1.9.0", rng: "1.x", want: true, wantCheckable: true},
+		{version: "2.0.0", rng: "1", want: false, wantCheckable: true},
+		{version: "2.5.0", rng: ">=1.2 <3", want: true, wantCheckable: true},
+		{version: "3.0.0", rng: ">= 1.2 < 3", want: false, wantCheckable: true},
+		{version: "4.1.0", rng: "<2 || 4.x", want: true, wantCheckable: true},
+		{version: "2.3.4", rng: "1.2.3 - 2.3", want: true, wantCheckable: true},
+		{version: "2.4.0", rng: "1.2.3 - 2.3", want: false, wantCheckable: true},
+		{version: "1.0.0", rng: "*", want: true, wantCheckable: true},
+		{version: "1.0.0", rng: "", want: true, wantCheckable: true},
+		{version: "1.2.3-beta.2", rng: "^1.2.3-beta", want: true, wantCheckable: true},
+		{version: "1.2.4", rng: "^1.2.3-beta", want: true, wantCheckable: true},
+		{version: "1.2.4-beta", rng: "^1.2.3-beta", want: false, wantCheckable: true},
+		{version: "1.2.3-alpha", rng: "^1.2.3-beta", want: false, wantCheckable: true},
+		{version: "1.2.3-beta", rng: "^1.2.3", want: false, wantCheckable: true},
+		{version: "2.0.0-rc.1", rng: "^1.2.3", want: false, wantCheckable: true},
+		{version: "1.3.0-alpha", rng: ">=1.2.0", want: false, wantCheckable: true},
+		{version: "1.3.0-alpha.1", rng: ">=1.3.0-alpha <2", want: true, wantCheckable: true},
+		{version: "1.2.3-beta.1", rng: "1.2.3-beta.1", want: true, wantCheckable: true},
+		{version: "1.2.3-beta.1", rng: "~1.2.3-beta || 2.x", want: true, wantCheckable: true},
+		{version: "3.0.0-beta", rng: "*", want: false, wantCheckable: true},
+		{version: "0.0.4", rng: "^0.0.3", want: false, wantCheckable: true},
+		{version: "1.0.0", rng: "latest", wantCheckable: false},
+		{version: "1.0.0", rng: "github:user/repo", wantCheckable: false},
+		{version: "1.0.0", rng: "file:../lib", wantCheckable: false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.version+" "+tc.rng, func(t *testing.T) {
+			got, checkable := Satisfies(tc.version, tc.rng)
+			if checkable != tc.wantCheckable {
+				t.Fatalf("Satisfies(%q, %q) checkable = %t, want %t", tc.version, tc.rng, checkable, tc.wantCheckable)
+			}
+			if got != tc.want {
+				t.Errorf("Satisfies(%q, %q) = %t, want %t", tc.version, tc.rng, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestLockfileDrift(t *testing.T) {
+	testCases := []struct {
+		name string
+		pjs  PackageJSON
+		lock packageLockJSON
+		want []string
+	}{
+		{
+			name: "in sync v1",
+			pjs:  PackageJSON{Dependencies: map[string]string{"express": "^4.17.1"}},
+			lock: packageLockJSON{Dependencies: map[string]lockedPackageJSON{"express": {Version: "4.17.3"}}},
+		},
+		{
+			name: "in sync v2",
+			pjs:  PackageJSON{Dependencies: map[string]string{"express": "^4.17.1"}, DevDependencies: map[string]string{"mocha": "~9.1.0"}},
+			lock: packageLockJSON{Packages: map[string]lockedPackageJSON{
+				"":                     {Version: "1.0.0"},
+				"node_modules/express": {Version: "4.17.1"},
+				"node_modules/mocha":   {Version: "9.1.3"},
+			}},
+		},
+		{
+			name: "outdated",
+			pjs:  PackageJSON{Dependencies: map[string]string{"express": "4.17.1"}},
+			lock: packageLockJSON{Dependencies: map[string]lockedPackageJSON{"express": {Version: "4.0.0"}}},
+			want: []string{`express: package.json requires "4.17.1", package-lock.json has "4.0.0"`},
+		},
+		{
+			name: "missing and unchecked",
+			pjs: PackageJSON{
+				Dependencies:    map[string]string{"express": "^4.17.1", "local": "file:../local"},
+				DevDependencies: map[string]string{"mocha": "^9.0.0"},
+			},
+			lock: packageLockJSON{Packages: map[string]lockedPackageJSON{
+				"node_modules/express": {Version: "4.17.1"},
+				"node_modules/local":   {},
+			}},
+			want: []string{`mocha: package.json requires "^9.0.0", package-lock.json has no entry`},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := lockfileDrift(&tc.pjs, &tc.lock, PackageLock)
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("lockfileDrift() = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestNativeAddonPackagesAndDependencyClosure(t *testing.T) {
+	d, err := ioutil.TempDir("/tmp", "test-node-modules-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(d)
+
+	files := map[string]string{
+		"express/package.json":                       `{"dependencies": {"accepts": "^1.3.7"}}`,
+		"accepts/package.json":                       `{}`,
+		"bcrypt/package.json":                        `{"dependencies": {"node-addon-api": "^3.1.0", "nested": "^1.0.0"}}`,
+		"bcrypt/binding.gyp":                         `{}`,
+		"bcrypt/node_modules/nested/package.json":    `{}`,
+		"node-addon-api/package.json":                `{}`,
+		"@scope/sharp/package.json":                  `{"optionalDependencies": {"detect-libc": "^2.0.0"}}`,
+		"@scope/sharp/build/Release/sharp.node":      "",
+		"detect-libc/package.json":                   `{}`,
+		".bin/functions-framework":                   "",
+		"@openfunction/functions-framework/index.js": "",
+	}
+	for f, c := range files {
+		fn := filepath.Join(d, f)
+		if err := os.MkdirAll(filepath.Dir(fn), 0755); err != nil {
+			t.Fatalf("Failed to create dir for %s: %v", f, err)
+		}
+		if err := ioutil.WriteFile(fn, []byte(c), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", f, err)
+		}
+	}
+
+	native, err := NativeAddonPackages(d)
+	if err != nil {
+		t.Fatalf("NativeAddonPackages(%q) got error: %v", d, err)
+	}
+	if want := []string{"@scope/sharp", "bcrypt"}; !reflect.DeepEqual(native, want) {
+		t.Errorf("NativeAddonPackages(%q) = %q, want %q", d, native, want)
+	}
+
+	closure, err := dependencyClosure(d, append(native, "@openfunction/functions-framework", "missing"))
+	if err != nil {
+		t.Fatalf("dependencyClosure() got error: %v", err)
+	}
+	want := map[string]bool{
+		"@scope/sharp":                      true,
+		"bcrypt":                            true,
+		"detect-libc":                       true,
+		"node-addon-api":                    true,
+		"@openfunction/functions-framework": true,
+	}
+	if !reflect.DeepEqual(closure, want) {
+		t.Errorf("dependencyClosure() = %v, want %v", closure, want)
+	}
+}
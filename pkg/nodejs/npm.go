@@ -15,8 +15,12 @@
 package nodejs
 
 import (
+	"encoding/json"
+	"fmt"
+	"sort"
 	"strings"
 
+	"github.com/GoogleCloudPlatform/buildpacks/pkg/env"
 	gcp "github.com/GoogleCloudPlatform/buildpacks/pkg/gcpbuildpack"
 )
 
@@ -27,18 +31,105 @@ const (
 	NPMShrinkwrap = "npm-shrinkwrap.json"
 )
 
+// packageLockJSON represents the parts of a package-lock.json or npm-shrinkwrap.json file used to detect drift.
+// Lockfile version 1 lists dependencies under `dependencies`, versions 2 and 3 under `packages`.
+type packageLockJSON struct {
+	Packages     map[string]lockedPackageJSON `json:"packages"`
+	Dependencies map[string]lockedPackageJSON `json:"dependencies"`
+}
+
+type lockedPackageJSON struct {
+	Version string `json:"version"`
+}
+
 // EnsureLockfile returns the name of the lockfile, generating a package-lock.json if necessary.
-func EnsureLockfile(ctx *gcp.Context) string {
+// The given options are applied to the npm command that generates the lockfile.
+// In strict lockfile mode, a missing lockfile or one that does not satisfy package.json is a user error.
+func EnsureLockfile(ctx *gcp.Context, opts ...gcp.ExecOption) (string, error) {
+	strict, err := env.IsNPMStrictLockfile()
+	if err != nil {
+		return "", gcp.UserErrorf("failed to parse %s: %v", env.NPMStrictLockfile, err)
+	}
+
 	// npm prefers npm-shrinkwrap.json, see https://docs.npmjs.com/cli/shrinkwrap.
+	lockfile := PackageLock
 	if ctx.FileExists(NPMShrinkwrap) {
-		return NPMShrinkwrap
-	}
-	if !ctx.FileExists(PackageLock) {
+		lockfile = NPMShrinkwrap
+	} else if !ctx.FileExists(PackageLock) {
+		if strict {
+			return "", gcp.UserErrorf("%s not found and %s is enabled, run `npm install` and commit the generated %s", PackageLock, env.NPMStrictLockfile, PackageLock)
+		}
 		ctx.Logf("Generating %s.", PackageLock)
 		ctx.Warnf("*** Improve build performance by generating and committing %s.", PackageLock)
-		ctx.Exec([]string{"npm", "install", "--package-lock-only", "--quiet"}, gcp.WithUserAttribution)
+		ctx.Exec([]string{"npm", "install", "--package-lock-only", "--quiet"}, append([]gcp.ExecOption{gcp.WithUserAttribution}, opts...)...)
+		return PackageLock, nil
+	}
+
+	if strict {
+		if err := checkLockfile(ctx, lockfile); err != nil {
+			return "", err
+		}
+	}
+	return lockfile, nil
+}
+
+// checkLockfile returns a user error listing the package.json dependencies that the lockfile does not satisfy.
+func checkLockfile(ctx *gcp.Context, lockfile string) error {
+	pjs, err := ReadPackageJSON(ctx.ApplicationRoot())
+	if err != nil {
+		return err
+	}
+	var lock packageLockJSON
+	if err := json.Unmarshal(ctx.ReadFile(lockfile), &lock); err != nil {
+		return gcp.UserErrorf("unmarshalling %s: %v", lockfile, err)
+	}
+	if drift := lockfileDrift(pjs, &lock, lockfile); len(drift) > 0 {
+		return gcp.UserErrorf("%s is out of sync with package.json, run `npm install` and commit the updated %s:\n  %s", lockfile, lockfile, strings.Join(drift, "\n  "))
+	}
+	return nil
+}
+
+// lockfileDrift returns a description of each dependency and devDependency in package.json whose range
+// is not satisfied by the version in the lockfile, sorted by package name. Dependencies that are not
+// specified by a version range, such as git URLs or local paths, are not checked.
+func lockfileDrift(pjs *PackageJSON, lock *packageLockJSON, lockfile string) []string {
+	deps := map[string]string{}
+	for name, rng := range pjs.DevDependencies {
+		deps[name] = rng
+	}
+	for name, rng := range pjs.Dependencies {
+		deps[name] = rng
+	}
+	names := make([]string, 0, len(deps))
+	for name := range deps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var drift []string
+	for _, name := range names {
+		rng := deps[name]
+		version, ok := lockedVersion(lock, name)
+		if !ok {
+			drift = append(drift, fmt.Sprintf("%s: package.json requires %q, %s has no entry", name, rng, lockfile))
+			continue
+		}
+		if matches, checkable := Satisfies(version, rng); checkable && !matches {
+			drift = append(drift, fmt.Sprintf("%s: package.json requires %q, %s has %q", name, rng, lockfile, version))
+		}
+	}
+	return drift
+}
+
+// lockedVersion returns the version of the top-level package with the given name in the lockfile.
+func lockedVersion(lock *packageLockJSON, name string) (string, bool) {
+	if p, ok := lock.Packages["node_modules/"+name]; ok {
+		return p.Version, true
+	}
+	if d, ok := lock.Dependencies[name]; ok {
+		return d.Version, true
 	}
-	return PackageLock
+	return "", false
 }
 
 // NPMInstallCommand returns the correct install command based on the version of Node.js.
@@ -0,0 +1,127 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nodejs
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	gcp "github.com/GoogleCloudPlatform/buildpacks/pkg/gcpbuildpack"
+	"github.com/buildpacks/libcnb"
+)
+
+const (
+	// NPMRCBindingType is the type of the service bindings that configure npm registries and their credentials.
+	// A binding either provides a complete `.npmrc` file, or a `registry` URL with an optional auth `token`
+	// and an optional package `scope` (e.g. `@my-org`) to which the registry applies.
+	NPMRCBindingType = "npmrc"
+
+	bindingNPMRCKey    = ".npmrc"
+	bindingRegistryKey = "registry"
+	bindingTokenKey    = "token"
+	bindingScopeKey    = "scope"
+
+	// npmUserConfigEnv points npm at an additional user-level .npmrc. Yarn v1 reads it too.
+	npmUserConfigEnv = "NPM_CONFIG_USERCONFIG"
+	// yarnRegistryServerEnv and yarnAuthTokenEnv configure the default registry of Yarn berry, which
+	// ignores .npmrc files.
+	yarnRegistryServerEnv = "YARN_NPM_REGISTRY_SERVER"
+	yarnAuthTokenEnv      = "YARN_NPM_AUTH_TOKEN"
+)
+
+// RegistryConfig materialises the npmrc service bindings into a temporary .npmrc for the duration of
+// dependency installation. It returns an ExecOption that points npm and yarn at the file, and a function
+// that removes it. The file lives outside of the application directory and the layers, so credentials
+// are neither cached nor exported to the image. Without npmrc bindings, the option is a no-op.
+// Yarn berry ignores .npmrc files, so the option also passes it the registry and token of a binding
+// without a scope; scoped registries and complete .npmrc files are not supported with Yarn berry.
+func RegistryConfig(ctx *gcp.Context) (gcp.ExecOption, func(), error) {
+	contents, err := npmrcContents(ctx.Bindings())
+	if err != nil {
+		return nil, nil, err
+	}
+	if contents == "" {
+		return gcp.WithEnv(), func() {}, nil
+	}
+
+	dir := ctx.TempDir("", "npmrc-")
+	npmrc := filepath.Join(dir, ".npmrc")
+	ctx.WriteFile(npmrc, []byte(contents), 0600)
+	ctx.Logf("Using npm registry configuration from %q service bindings.", NPMRCBindingType)
+	env := append([]string{npmUserConfigEnv + "=" + npmrc}, yarnRegistryEnv(ctx.Bindings())...)
+	return gcp.WithEnv(env...), func() { ctx.RemoveAll(dir) }, nil
+}
+
+// yarnRegistryEnv returns the environment variables that point Yarn berry at the registry of the last
+// npmrc binding without a scope, with its auth token, or nil if there is no such binding.
+func yarnRegistryEnv(bindings libcnb.Bindings) []string {
+	var env []string
+	for _, b := range bindings {
+		if b.Type != NPMRCBindingType || b.Secret[bindingScopeKey] != "" {
+			continue
+		}
+		registry, ok := b.Secret[bindingRegistryKey]
+		if _, npmrc := b.Secret[bindingNPMRCKey]; npmrc || !ok || registry == "" {
+			continue
+		}
+		env = []string{yarnRegistryServerEnv + "=" + strings.TrimSuffix(registry, "/")}
+		if token := b.Secret[bindingTokenKey]; token != "" {
+			env = append(env, yarnAuthTokenEnv+"="+token)
+		}
+	}
+	return env
+}
+
+// npmrcContents returns the .npmrc contents for all npmrc bindings, or an empty string if there are none.
+func npmrcContents(bindings libcnb.Bindings) (string, error) {
+	var lines []string
+	for _, b := range bindings {
+		if b.Type != NPMRCBindingType {
+			continue
+		}
+		if npmrc, ok := b.Secret[bindingNPMRCKey]; ok {
+			lines = append(lines, npmrc)
+			continue
+		}
+		registry, ok := b.Secret[bindingRegistryKey]
+		if !ok || registry == "" {
+			return "", gcp.UserErrorf("%s binding %q must provide either %q or %q", NPMRCBindingType, b.Name, bindingNPMRCKey, bindingRegistryKey)
+		}
+		schemeEnd := strings.Index(registry, "//")
+		if schemeEnd < 0 {
+			return "", gcp.UserErrorf("%s binding %q has invalid registry URL %q", NPMRCBindingType, b.Name, registry)
+		}
+		if !strings.HasSuffix(registry, "/") {
+			registry += "/"
+		}
+		if scope := b.Secret[bindingScopeKey]; scope != "" {
+			if !strings.HasPrefix(scope, "@") {
+				scope = "@" + scope
+			}
+			lines = append(lines, fmt.Sprintf("%s:registry=%s", scope, registry))
+		} else {
+			lines = append(lines, "registry="+registry)
+		}
+		if token := b.Secret[bindingTokenKey]; token != "" {
+			// Credentials are keyed by the registry URL without its scheme, e.g. //npm.example.com/.
+			lines = append(lines, fmt.Sprintf("%s:_authToken=%s", registry[schemeEnd:], token))
+		}
+	}
+	if len(lines) == 0 {
+		return "", nil
+	}
+	return strings.Join(lines, "\n") + "\n", nil
+}
@@ -0,0 +1,84 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nodejs
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/GoogleCloudPlatform/buildpacks/pkg/cache"
+	gcp "github.com/GoogleCloudPlatform/buildpacks/pkg/gcpbuildpack"
+)
+
+const (
+	prunedLayer = "pruned"
+
+	// PrunedDependenciesEnv is set for the buildpacks that run after a gcp-build buildpack has pruned
+	// node_modules to the production dependencies, so that they keep it instead of reinstalling them.
+	PrunedDependenciesEnv = "GOOGLE_INTERNAL_PRUNED_DEPENDENCIES"
+)
+
+// PruneDevDependencies reduces node_modules to the production dependencies after a gcp-build script
+// that required devDependencies has run, and logs the bytes saved. The given command performs the prune,
+// e.g. `npm prune --production`; the given options are applied to it. The pruned node_modules is cached
+// in a layer keyed by package.json and the lockfile, so builds with unchanged dependencies restore it
+// instead of pruning again.
+func PruneDevDependencies(ctx *gcp.Context, lockfile string, cmd []string, opts ...gcp.ExecOption) error {
+	before, err := dirSize("node_modules")
+	if err != nil {
+		return gcp.InternalErrorf("computing size of node_modules: %v", err)
+	}
+
+	l := ctx.Layer(prunedLayer, gcp.BuildLayer, gcp.CacheLayer)
+	nm := filepath.Join(l.Path, "node_modules")
+	cached, err := CheckCache(ctx, l, cache.WithStrings(EnvProduction), cache.WithFiles("package.json", lockfile))
+	if err != nil {
+		return fmt.Errorf("checking cache: %w", err)
+	}
+	if cached {
+		ctx.CacheHit(prunedLayer)
+		// Replace node_modules with the cached production dependencies.
+		ctx.RemoveAll("node_modules")
+		ctx.Exec([]string{"cp", "--archive", nm, "node_modules"}, gcp.WithUserTimingAttribution)
+		RebuildNativeAddons(ctx, l, append([]gcp.ExecOption{gcp.WithEnv("NODE_ENV=" + EnvProduction)}, opts...)...)
+	} else {
+		ctx.CacheMiss(prunedLayer)
+		// Clear cached node_modules to ensure we don't end up with outdated dependencies after copying.
+		ctx.ClearLayer(l)
+		ctx.Exec(cmd, append([]gcp.ExecOption{gcp.WithEnv("NODE_ENV=" + EnvProduction), WithNativeAddonTips(ctx), gcp.WithUserAttribution}, opts...)...)
+		// Ensure node_modules exists even if no production dependencies remain.
+		ctx.MkdirAll("node_modules", 0755)
+		ctx.Exec([]string{"cp", "--archive", "node_modules", nm}, gcp.WithUserTimingAttribution)
+	}
+	l.BuildEnvironment.Override(PrunedDependenciesEnv, "true")
+
+	after, err := dirSize("node_modules")
+	if err != nil {
+		return gcp.InternalErrorf("computing size of node_modules: %v", err)
+	}
+	ctx.Logf("Pruned devDependencies from node_modules: %d bytes before, %d bytes after, %d bytes saved.", before, after, before-after)
+	return nil
+}
+
+// UsePrunedDependencies returns true if a gcp-build buildpack left node_modules pruned to the production
+// dependencies, which the npm and yarn buildpacks then use as is. It is false unless NODE_ENV is production.
+func UsePrunedDependencies(ctx *gcp.Context) bool {
+	if os.Getenv(PrunedDependenciesEnv) == "" || NodeEnv() != EnvProduction || !ctx.FileExists("node_modules") {
+		return false
+	}
+	ctx.Logf("Using the node_modules pruned to production dependencies after gcp-build.")
+	return true
+}
@@ -0,0 +1,183 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nodejs
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/blang/semver"
+)
+
+var (
+	// partialRegexp matches a possibly partial version with an optional operator, e.g. `^1.2`, `>=1.x` or `~1.2.3-beta.1`.
+	partialRegexp = regexp.MustCompile(`^(<=|>=|<|>|=|\^|~)?\s*v?(\d+|[xX*])(?:\.(\d+|[xX*]))?(?:\.(\d+|[xX*]))?(-[0-9A-Za-z.-]+)?(\+[0-9A-Za-z.-]+)?$`)
+	// hyphenRegexp matches the separator of a hyphen range, e.g. `1.2.3 - 2.3.4`.
+	hyphenRegexp = regexp.MustCompile(`\s+-\s+`)
+	// operatorSpaceRegexp matches whitespace between an operator and its version, e.g. `>= 1.2.3`.
+	operatorSpaceRegexp = regexp.MustCompile(`(<=|>=|<|>|=|\^|~)\s+`)
+)
+
+// comparatorSet is a set of npm range expressions that must all match, e.g. `>=1.2 <3`.
+type comparatorSet struct {
+	r semver.Range
+	// prereleases are the versions with a prerelease tag in the set. Like npm, a prerelease version only
+	// matches if one of them has the same major, minor and patch version, e.g. `^1.2.3-beta` matches
+	// `1.2.3-beta.2` but not `1.2.4-beta`.
+	prereleases []semver.Version
+}
+
+func (cs comparatorSet) matches(v semver.Version) bool {
+	if !cs.r(v) {
+		return false
+	}
+	if len(v.Pre) == 0 {
+		return true
+	}
+	for _, p := range cs.prereleases {
+		if p.Major == v.Major && p.Minor == v.Minor && p.Patch == v.Patch {
+			return true
+		}
+	}
+	return false
+}
+
+// Satisfies reports whether version satisfies the npm semver range rng, e.g. `^4.17.1` or `>=1.2 <3 || 4.x`.
+// The second return value is false if the range cannot be evaluated, which is the case for dependencies
+// that are not specified by a version range, such as dist-tags, git URLs, tarballs and local paths.
+func Satisfies(version, rng string) (bool, bool) {
+	v, err := semver.ParseTolerant(version)
+	if err != nil {
+		return false, false
+	}
+	matches := false
+	for _, set := range strings.Split(rng, "||") {
+		cs, err := parseComparatorSet(set)
+		if err != nil {
+			return false, false
+		}
+		matches = matches || cs.matches(v)
+	}
+	return matches, true
+}
+
+// parseComparatorSet translates a space-separated set of npm range expressions into a range of
+// github.com/blang/semver, which only supports comparisons with full versions.
+func parseComparatorSet(set string) (comparatorSet, error) {
+	var exprs []string
+	set = strings.TrimSpace(set)
+	if parts := hyphenRegexp.Split(set, -1); len(parts) == 2 {
+		exprs = []string{">=" + parts[0], "<=" + parts[1]}
+	} else {
+		exprs = strings.Fields(operatorSpaceRegexp.ReplaceAllString(set, "$1"))
+	}
+
+	var cs comparatorSet
+	var comparators []string
+	for _, expr := range exprs {
+		c, pre, err := translate(expr)
+		if err != nil {
+			return comparatorSet{}, err
+		}
+		comparators = append(comparators, c...)
+		if pre != nil {
+			cs.prereleases = append(cs.prereleases, *pre)
+		}
+	}
+	if len(comparators) == 0 {
+		// An empty set or a bare wildcard such as `*` matches any version.
+		comparators = []string{">=0.0.0"}
+	}
+	r, err := semver.ParseRange(strings.Join(comparators, " "))
+	if err != nil {
+		return comparatorSet{}, err
+	}
+	cs.r = r
+	return cs, nil
+}
+
+// translate converts an npm range expression such as `^1.2`, `~1.2.3-beta`, `1.x` or `<=2` into comparators on
+// full versions, e.g. `>=1.2.0 <2.0.0`. It also returns the version of the expression if it has a prerelease tag.
+func translate(expr string) ([]string, *semver.Version, error) {
+	m := partialRegexp.FindStringSubmatch(expr)
+	if m == nil {
+		return nil, nil, fmt.Errorf("invalid range expression %q", expr)
+	}
+	op := m[1]
+
+	// Collect the leading numeric components; anything from an `x` wildcard or a missing component on is a wildcard.
+	var nums []uint64
+	for _, p := range m[2:5] {
+		if p == "" || p == "x" || p == "X" || p == "*" {
+			break
+		}
+		num, err := strconv.ParseUint(p, 10, 64)
+		if err != nil {
+			return nil, nil, err
+		}
+		nums = append(nums, num)
+	}
+	n := len(nums)
+	if n == 0 {
+		return nil, nil, nil
+	}
+	for len(nums) < 3 {
+		nums = append(nums, 0)
+	}
+	base := semver.Version{Major: nums[0], Minor: nums[1], Patch: nums[2]}
+	var pre *semver.Version
+	if n == 3 && m[5] != "" {
+		v, err := semver.Parse(base.String() + m[5])
+		if err != nil {
+			return nil, nil, err
+		}
+		base, pre = v, &v
+	}
+
+	var next semver.Version
+	switch {
+	case op == "^" && (base.Major > 0 || n == 1), op == "~" && n == 1, n == 1:
+		next = semver.Version{Major: base.Major + 1}
+	case op == "^" && (base.Minor > 0 || n == 2), op == "~", n == 2:
+		next = semver.Version{Major: base.Major, Minor: base.Minor + 1}
+	default:
+		next = semver.Version{Major: base.Major, Minor: base.Minor, Patch: base.Patch + 1}
+	}
+
+	switch op {
+	case "^", "~":
+		return []string{">=" + base.String(), "<" + next.String()}, pre, nil
+	case "", "=":
+		if n == 3 {
+			return []string{"=" + base.String()}, pre, nil
+		}
+		return []string{">=" + base.String(), "<" + next.String()}, pre, nil
+	case ">=", "<":
+		return []string{op + base.String()}, pre, nil
+	case ">":
+		if n == 3 {
+			return []string{">" + base.String()}, pre, nil
+		}
+		return []string{">=" + next.String()}, pre, nil
+	case "<=":
+		if n == 3 {
+			return []string{"<=" + base.String()}, pre, nil
+		}
+		return []string{"<" + next.String()}, pre, nil
+	}
+	return nil, nil, fmt.Errorf("unsupported operator %q", op)
+}
@@ -0,0 +1,196 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package python
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/buildpacks/pkg/env"
+	gcp "github.com/GoogleCloudPlatform/buildpacks/pkg/gcpbuildpack"
+)
+
+var (
+	// pinRegexp matches an exact version pin without wildcards, e.g. `==2.26.0` or `===1.0-custom`.
+	pinRegexp = regexp.MustCompile(`===?\s*[^\s,;*]+(\s|;|$)`)
+	// rangeRegexp matches version specifiers other than an exact pin.
+	rangeRegexp = regexp.MustCompile(`(~=|!=|<=|>=|<|>)`)
+)
+
+// pipEnforcementOptions returns the pip options that enforce FUNC_PIP_REQUIRE_HASHES and FUNC_PIP_ONLY_BINARY
+// for the application requirements, and whether hashes are required.
+func pipEnforcementOptions() ([]string, bool, error) {
+	requireHashes, err := env.IsPipRequireHashes()
+	if err != nil {
+		return nil, false, gcp.UserErrorf("failed to parse %s: %v", env.PipRequireHashes, err)
+	}
+	var opts []string
+	if requireHashes {
+		opts = append(opts, "--require-hashes")
+	}
+	if onlyBinary := strings.TrimSpace(os.Getenv(env.PipOnlyBinary)); onlyBinary != "" {
+		opts = append(opts, "--only-binary", onlyBinary)
+	}
+	return opts, requireHashes, nil
+}
+
+// checkPinnedRequirements returns a user error that lists every requirement in the given requirements
+// files that is not pinned to an exact version with at least one hash, or nil if all of them are.
+func checkPinnedRequirements(reqs ...string) error {
+	var problems []string
+	for _, req := range reqs {
+		p, err := unpinnedRequirements(req, map[string]bool{})
+		if err != nil {
+			return gcp.UserErrorf("checking requirements in %s: %v", req, err)
+		}
+		problems = append(problems, p...)
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	return gcp.UserErrorf("%s requires every requirement to be pinned to an exact version with hashes, e.g. generated with `pip-compile --generate-hashes`; found %d that are not:\n  %s",
+		env.PipRequireHashes, len(problems), strings.Join(problems, "\n  "))
+}
+
+// unpinnedRequirements returns a description of each requirement in the given requirements file, and in
+// the requirements files it includes, that lacks an exact version pin or a hash.
+func unpinnedRequirements(path string, seen map[string]bool) ([]string, error) {
+	if seen[path] {
+		return nil, nil
+	}
+	seen[path] = true
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var problems []string
+	for _, l := range logicalLines(string(raw)) {
+		line := l.text
+		if included, ok := includedRequirements(line); ok {
+			if !filepath.IsAbs(included) {
+				included = filepath.Join(filepath.Dir(path), included)
+			}
+			p, err := unpinnedRequirements(included, seen)
+			if err != nil {
+				return nil, err
+			}
+			problems = append(problems, p...)
+			continue
+		}
+
+		var missing []string
+		switch {
+		case strings.HasPrefix(line, "-e ") || strings.HasPrefix(line, "--editable"):
+			missing = append(missing, "editable requirements cannot be hash-checked")
+		case strings.HasPrefix(line, "-"):
+			// Global options such as --index-url apply to all requirements.
+			continue
+		default:
+			spec := strings.SplitN(line, " --hash", 2)[0]
+			// Environment markers such as `python_version == "3.8"` are not version specifiers.
+			version := strings.SplitN(spec, ";", 2)[0]
+			// Requirements for a URL or path are identified by their hash rather than a version.
+			direct := strings.Contains(spec, "@") || strings.Contains(spec, "://") || strings.HasPrefix(spec, ".") || strings.HasPrefix(spec, "/")
+			if !direct && (!pinRegexp.MatchString(version) || rangeRegexp.MatchString(version)) {
+				missing = append(missing, "no exact == version pin")
+			}
+			if !strings.Contains(line, "--hash=") {
+				missing = append(missing, "no --hash")
+			}
+		}
+		if len(missing) > 0 {
+			problems = append(problems, fmt.Sprintf("%s:%d: %s (%s)", filepath.Base(path), l.number, strings.SplitN(line, " --hash", 2)[0], strings.Join(missing, ", ")))
+		}
+	}
+	return problems, nil
+}
+
+// hashedRequirements returns true if any requirement in the given requirements file, or in the requirements
+// files it includes, has a hash, which puts pip into hash-checking mode for all of them.
+func hashedRequirements(path string, seen map[string]bool) (bool, error) {
+	if seen[path] {
+		return false, nil
+	}
+	seen[path] = true
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return false, err
+	}
+	for _, l := range logicalLines(string(raw)) {
+		included, ok := includedRequirements(l.text)
+		if !ok {
+			if strings.Contains(l.text, "--hash=") {
+				return true, nil
+			}
+			continue
+		}
+		if !filepath.IsAbs(included) {
+			included = filepath.Join(filepath.Dir(path), included)
+		}
+		hashed, err := hashedRequirements(included, seen)
+		if err != nil || hashed {
+			return hashed, err
+		}
+	}
+	return false, nil
+}
+
+// includedRequirements returns the requirements file that the given line includes, if any.
+func includedRequirements(line string) (string, bool) {
+	for _, prefix := range []string{"-r ", "--requirement=", "--requirement "} {
+		if strings.HasPrefix(line, prefix) {
+			return strings.TrimSpace(line[len(prefix):]), true
+		}
+	}
+	return "", false
+}
+
+// logicalLine is a requirements file line with continuations joined and comments removed.
+type logicalLine struct {
+	number int
+	text   string
+}
+
+// logicalLines returns the non-empty logical lines of a requirements file, numbered by their first physical line.
+func logicalLines(contents string) []logicalLine {
+	var lines []logicalLine
+	var current []string
+	start := 0
+	for i, physical := range strings.Split(contents, "\n") {
+		if len(current) == 0 {
+			start = i + 1
+		}
+		// Comments start with # at the beginning of the line or after whitespace.
+		if idx := strings.Index(physical, "#"); idx == 0 || (idx > 0 && strings.ContainsAny(physical[idx-1:idx], " \t")) {
+			physical = physical[:idx]
+		}
+		trimmed := strings.TrimSpace(physical)
+		if strings.HasSuffix(trimmed, "\\") {
+			current = append(current, strings.TrimSpace(strings.TrimSuffix(trimmed, "\\")))
+			continue
+		}
+		current = append(current, trimmed)
+		if text := strings.TrimSpace(strings.Join(current, " ")); text != "" {
+			lines = append(lines, logicalLine{start, strings.Join(strings.Fields(text), " ")})
+		}
+		current = nil
+	}
+	return lines
+}
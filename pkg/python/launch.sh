@@ -0,0 +1,85 @@
+#!/bin/bash
+# Copyright 2020 Google LLC
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+
+# Python web server launcher that sizes the server to the container limits.
+# Unless $WEB_CONCURRENCY is set, the number of workers is 2 * CPUs + 1, as
+# recommended by gunicorn, with the CPUs taken from the quota of the cgroup
+# (v2 or v1). If the cgroup has a memory limit, the number of workers is capped
+# so that each one has $PYTHON_WORKER_MEMORY_MB (default 128) of it. Unless
+# $PYTHON_THREADS is set, the workers share 8 threads per CPU.
+
+limit=""
+if [[ -r /sys/fs/cgroup/memory.max ]]; then
+  limit="$(cat /sys/fs/cgroup/memory.max)"
+elif [[ -r /sys/fs/cgroup/memory/memory.limit_in_bytes ]]; then
+  limit="$(cat /sys/fs/cgroup/memory/memory.limit_in_bytes)"
+fi
+
+quota=""
+period=""
+if [[ -r /sys/fs/cgroup/cpu.max ]]; then
+  read -r quota period < /sys/fs/cgroup/cpu.max
+elif [[ -r /sys/fs/cgroup/cpu/cpu.cfs_quota_us && -r /sys/fs/cgroup/cpu/cpu.cfs_period_us ]]; then
+  quota="$(cat /sys/fs/cgroup/cpu/cpu.cfs_quota_us)"
+  period="$(cat /sys/fs/cgroup/cpu/cpu.cfs_period_us)"
+fi
+
+cpus="$(nproc)"
+# cgroup v2 reports "max" and cgroup v1 reports -1 without a quota.
+if [[ "${quota}" =~ ^[0-9]+$ && "${period}" =~ ^[0-9]+$ ]] && (( quota > 0 && period > 0 )); then
+  quota_cpus=$(( (quota + period - 1) / period ))
+  if (( quota_cpus < cpus )); then
+    cpus=${quota_cpus}
+  fi
+fi
+
+if [[ -n "${WEB_CONCURRENCY}" && ! "${WEB_CONCURRENCY}" =~ ^[1-9][0-9]*$ ]]; then
+  echo "Ignoring invalid WEB_CONCURRENCY=${WEB_CONCURRENCY}." >&2
+  unset WEB_CONCURRENCY
+fi
+if [[ -z "${WEB_CONCURRENCY}" ]]; then
+  workers=$(( 2 * cpus + 1 ))
+
+  worker_memory="${PYTHON_WORKER_MEMORY_MB:-128}"
+  if ! [[ "${worker_memory}" =~ ^[1-9][0-9]*$ ]]; then
+    echo "Ignoring invalid PYTHON_WORKER_MEMORY_MB=${worker_memory}, using 128." >&2
+    worker_memory=128
+  fi
+  # cgroup v2 reports "max" without a limit; cgroup v1 reports a value close to
+  # the largest 64-bit integer, so treat anything above 1 TiB as unlimited.
+  if [[ "${limit}" =~ ^[0-9]+$ ]] && (( limit < 1 << 40 )); then
+    max_workers=$(( limit / 1024 / 1024 / worker_memory ))
+    if (( max_workers < workers )); then
+      workers=${max_workers}
+    fi
+  fi
+  if (( workers < 1 )); then
+    workers=1
+  fi
+  export WEB_CONCURRENCY=${workers}
+fi
+
+if [[ -n "${PYTHON_THREADS}" && ! "${PYTHON_THREADS}" =~ ^[1-9][0-9]*$ ]]; then
+  echo "Ignoring invalid PYTHON_THREADS=${PYTHON_THREADS}." >&2
+  unset PYTHON_THREADS
+fi
+if [[ -z "${PYTHON_THREADS}" ]]; then
+  threads=$(( 8 * cpus / WEB_CONCURRENCY ))
+  if (( threads < 1 )); then
+    threads=1
+  fi
+  export PYTHON_THREADS=${threads}
+fi
+exec "$@"
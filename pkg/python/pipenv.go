@@ -0,0 +1,132 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package python
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	gcp "github.com/GoogleCloudPlatform/buildpacks/pkg/gcpbuildpack"
+)
+
+const (
+	// Pipfile is the name of the Pipenv project file.
+	Pipfile = "Pipfile"
+	// PipfileLock is the name of the Pipenv lock file.
+	PipfileLock = "Pipfile.lock"
+)
+
+// pipfileLockJSON represents a Pipfile.lock file. The `default` section holds the locked packages
+// required at run time, the `develop` section the development packages, which are not installed.
+type pipfileLockJSON struct {
+	Default map[string]pipfilePackage `json:"default"`
+}
+
+type pipfilePackage struct {
+	Version  string   `json:"version"`
+	Hashes   []string `json:"hashes"`
+	Markers  string   `json:"markers"`
+	Extras   []string `json:"extras"`
+	Git      string   `json:"git"`
+	Ref      string   `json:"ref"`
+	Path     string   `json:"path"`
+	File     string   `json:"file"`
+	Editable bool     `json:"editable"`
+}
+
+// ExportPipfileLock writes the packages locked in the `default` section of Pipfile.lock to a requirements
+// file in the given directory and returns its path, or an empty path if there is no Pipfile.lock.
+// The generated file is keyed by Pipfile.lock, see writeRequirements.
+func ExportPipfileLock(ctx *gcp.Context, dir string) (string, error) {
+	if !ctx.FileExists(PipfileLock) {
+		if ctx.FileExists(Pipfile) {
+			ctx.Warnf("Found %s without %s, run `pipenv lock` and commit %s to install its packages.", Pipfile, PipfileLock, PipfileLock)
+		}
+		return "", nil
+	}
+	var lock pipfileLockJSON
+	if err := json.Unmarshal(ctx.ReadFile(PipfileLock), &lock); err != nil {
+		return "", gcp.UserErrorf("parsing %s: %v", PipfileLock, err)
+	}
+	reqs, hashed := pipfileRequirements(&lock, ctx.ApplicationRoot())
+	if !hashed {
+		ctx.Warnf("Some packages in %s have no hashes, installing all packages without hash checking.", PipfileLock)
+	}
+	return writeRequirements(ctx, dir, reqs, PipfileLock), nil
+}
+
+// pipfileRequirements returns pinned requirements for the packages in the `default` section of the
+// lock, sorted by name. Requirements include the package hashes unless any package has none, e.g.
+// because it is installed from a git repository, in which case the second return value is false.
+func pipfileRequirements(lock *pipfileLockJSON, root string) (string, bool) {
+	names := make([]string, 0, len(lock.Default))
+	hashed := true
+	for name, p := range lock.Default {
+		names = append(names, name)
+		if len(p.Hashes) == 0 {
+			hashed = false
+		}
+	}
+	sort.Strings(names)
+
+	var sb strings.Builder
+	for _, name := range names {
+		p := lock.Default[name]
+		project := name
+		if len(p.Extras) > 0 {
+			extras := append([]string{}, p.Extras...)
+			sort.Strings(extras)
+			project = fmt.Sprintf("%s[%s]", name, strings.Join(extras, ","))
+		}
+
+		var req string
+		switch {
+		case p.Git != "":
+			req = fmt.Sprintf("%s @ git+%s", project, p.Git)
+			if p.Ref != "" {
+				req += "@" + p.Ref
+			}
+		case p.Path != "":
+			path := p.Path
+			if !filepath.IsAbs(path) {
+				path = filepath.Join(root, path)
+			}
+			req = fmt.Sprintf("%s @ file://%s", project, path)
+			if p.Editable {
+				req = "--editable " + path
+			}
+		case p.File != "":
+			req = fmt.Sprintf("%s @ %s", project, p.File)
+		default:
+			// Pipfile.lock versions include the operator, e.g. `==2.26.0`.
+			req = project + p.Version
+		}
+		if p.Markers != "" && !p.Editable {
+			req += " ; " + p.Markers
+		}
+		if hashed {
+			hashes := append([]string{}, p.Hashes...)
+			sort.Strings(hashes)
+			for _, hash := range hashes {
+				req += " \\\n    --hash=" + hash
+			}
+		}
+		sb.WriteString(req + "\n")
+	}
+	return sb.String(), hashed
+}
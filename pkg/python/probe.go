@@ -0,0 +1,160 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package python
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	gcp "github.com/GoogleCloudPlatform/buildpacks/pkg/gcpbuildpack"
+	"github.com/buildpacks/libcnb"
+)
+
+const (
+	// probeReportPrefix marks the line of the probe output that holds the report, which follows
+	// anything the function source prints when it is imported.
+	probeReportPrefix = "FUNCTION_PROBE_REPORT:"
+
+	// probeTimeout limits how long the probe may run, e.g. when the function source starts a server or
+	// waits on a network connection when it is imported. `timeout` exits with probeTimeoutExitCode then.
+	probeTimeout         = "60s"
+	probeTimeoutExitCode = 124
+
+	// probeScript imports the function source the way the functions framework does and reports the
+	// top-level callables it defines and the signature of the target.
+	probeScript = `
+import importlib.util, inspect, json, os, sys
+
+source, target = sys.argv[1], sys.argv[2]
+sys.path.insert(0, os.path.dirname(os.path.abspath(source)))
+spec = importlib.util.spec_from_file_location("main", source)
+module = importlib.util.module_from_spec(spec)
+sys.modules["main"] = module
+spec.loader.exec_module(module)
+
+report = {
+    "callables": sorted(n for n, v in vars(module).items()
+                        if not n.startswith("_") and callable(v) and getattr(v, "__module__", None) == "main"),
+    "exists": hasattr(module, target),
+}
+obj = getattr(module, target, None)
+report["callable"] = callable(obj)
+if callable(obj):
+    try:
+        params = list(inspect.signature(obj).parameters.values())
+        positional = [p for p in params if p.kind in (p.POSITIONAL_ONLY, p.POSITIONAL_OR_KEYWORD)]
+        report["min_args"] = len([p for p in positional if p.default is p.empty])
+        report["max_args"] = -1 if any(p.kind == p.VAR_POSITIONAL for p in params) else len(positional)
+    except (TypeError, ValueError):
+        pass
+print("` + probeReportPrefix + `" + json.dumps(report))
+`
+)
+
+// signatureArgs is the number of positional arguments the functions framework passes to a function
+// of each signature type.
+var signatureArgs = map[string]int{
+	"http":       1, // request
+	"event":      2, // data, context
+	"cloudevent": 1, // cloud_event
+}
+
+// probeReport is the report printed by probeScript.
+type probeReport struct {
+	Callables []string `json:"callables"`
+	Exists    bool     `json:"exists"`
+	Callable  bool     `json:"callable"`
+	MinArgs   *int     `json:"min_args"`
+	MaxArgs   *int     `json:"max_args"`
+}
+
+// ValidateFunctionTarget imports the function source in a subprocess, with the dependencies installed
+// in the given layer, and returns a user error if the target is not a callable that accepts the
+// arguments of the signature type. If the source cannot be imported at build time, e.g. because it
+// requires configuration that is only available at run time, or does not finish within probeTimeout,
+// a warning is logged instead.
+func ValidateFunctionTarget(ctx *gcp.Context, l *libcnb.Layer, source, target, signatureType string) error {
+	python, opts := layerPython(l)
+	opts = append(opts, gcp.WithUserAttribution)
+
+	ctx.Logf("Validating function %q in %s.", target, source)
+	cmd := []string{"timeout", "--kill-after=5s", probeTimeout, python, "-c", probeScript, source, target}
+	result, err := ctx.ExecWithErr(cmd, opts...)
+	if err != nil {
+		if result != nil && result.ExitCode == probeTimeoutExitCode {
+			ctx.Warnf("Skipping validation of function %q, importing %s did not finish within %s.", target, source, probeTimeout)
+			return nil
+		}
+		output := ""
+		if result != nil {
+			output = result.Combined
+		}
+		ctx.Warnf("Skipping validation of function %q, importing %s failed: %s", target, source, output)
+		return nil
+	}
+
+	var report probeReport
+	found := false
+	for _, line := range strings.Split(result.Stdout, "\n") {
+		if strings.HasPrefix(line, probeReportPrefix) {
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, probeReportPrefix)), &report); err != nil {
+				return gcp.InternalErrorf("parsing function probe report: %v", err)
+			}
+			found = true
+		}
+	}
+	if !found {
+		return gcp.InternalErrorf("function probe did not report on %s", source)
+	}
+	if problem := targetProblem(&report, target, signatureType); problem != "" {
+		available := "none"
+		if len(report.Callables) > 0 {
+			available = strings.Join(report.Callables, ", ")
+		}
+		return gcp.UserErrorf("%s in %s. Available top-level callables: %s", problem, source, available)
+	}
+	return nil
+}
+
+// targetProblem describes why the target in the report cannot serve as a function of the given
+// signature type, or returns an empty string if it can.
+func targetProblem(report *probeReport, target, signatureType string) string {
+	switch {
+	case !report.Exists:
+		return fmt.Sprintf("function %q is not defined", target)
+	case !report.Callable:
+		return fmt.Sprintf("%q is not callable", target)
+	}
+	args, ok := signatureArgs[signatureType]
+	if !ok || report.MinArgs == nil || report.MaxArgs == nil {
+		return ""
+	}
+	if *report.MinArgs > args || (*report.MaxArgs >= 0 && *report.MaxArgs < args) {
+		return fmt.Sprintf("function %q must accept %d positional argument(s) for signature type %q, but accepts %s", target, args, signatureType, arity(*report.MinArgs, *report.MaxArgs))
+	}
+	return ""
+}
+
+// arity describes the number of positional arguments a function accepts.
+func arity(min, max int) string {
+	switch {
+	case max < 0:
+		return fmt.Sprintf("%d or more", min)
+	case min == max:
+		return fmt.Sprintf("%d", min)
+	}
+	return fmt.Sprintf("%d to %d", min, max)
+}
@@ -0,0 +1,383 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package python
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	gcp "github.com/GoogleCloudPlatform/buildpacks/pkg/gcpbuildpack"
+)
+
+const (
+	// PyprojectTOML is the name of the PEP 518 project file.
+	PyprojectTOML = "pyproject.toml"
+	// PoetryLock is the name of the Poetry lock file.
+	PoetryLock = "poetry.lock"
+
+	// exportedRequirements is the name of the requirements file generated from a dependency lock or project file.
+	exportedRequirements = "requirements-exported.txt"
+)
+
+var (
+	// nameRegexp matches the project name at the start of a PEP 508 requirement, e.g. `requests` in `requests[socks]>=2.0`.
+	nameRegexp = regexp.MustCompile(`^\s*([A-Za-z0-9][A-Za-z0-9._-]*)`)
+	// extrasRegexp matches the extras of a PEP 508 requirement, e.g. `socks` in `requests[socks]>=2.0`.
+	extrasRegexp = regexp.MustCompile(`^\s*[A-Za-z0-9][A-Za-z0-9._-]*\s*\[([^\]]*)\]`)
+	// separatorRegexp matches the separators that PEP 503 normalizes in project names.
+	separatorRegexp = regexp.MustCompile(`[-_.]+`)
+)
+
+// pyprojectTOML represents the parts of a pyproject.toml file that declare dependencies.
+type pyprojectTOML struct {
+	Project *struct {
+		Dependencies []string `toml:"dependencies"`
+		Dynamic      []string `toml:"dynamic"`
+	} `toml:"project"`
+	BuildSystem *struct {
+		Requires     []string `toml:"requires"`
+		BuildBackend string   `toml:"build-backend"`
+	} `toml:"build-system"`
+	Tool struct {
+		Poetry struct {
+			Dependencies map[string]interface{} `toml:"dependencies"`
+		} `toml:"poetry"`
+	} `toml:"tool"`
+}
+
+// poetryLockTOML represents a poetry.lock file. Lock files before format 2.0 list the files of
+// each package under `metadata.files` instead of in the package.
+type poetryLockTOML struct {
+	Package  []poetryPackage `toml:"package"`
+	Metadata struct {
+		Files map[string][]poetryFile `toml:"files"`
+	} `toml:"metadata"`
+}
+
+type poetryPackage struct {
+	Name         string                 `toml:"name"`
+	Version      string                 `toml:"version"`
+	Markers      interface{}            `toml:"markers"`
+	Dependencies map[string]interface{} `toml:"dependencies"`
+	Extras       map[string][]string    `toml:"extras"`
+	Files        []poetryFile           `toml:"files"`
+	Source       *struct {
+		Type              string `toml:"type"`
+		URL               string `toml:"url"`
+		ResolvedReference string `toml:"resolved_reference"`
+	} `toml:"source"`
+}
+
+type poetryFile struct {
+	File string `toml:"file"`
+	Hash string `toml:"hash"`
+}
+
+// ExportProjectRequirements writes the dependencies of a pyproject.toml project to a requirements file
+// in the given directory and returns its path, or an empty path if there is nothing to install.
+// The dependencies are taken from the first of the following that applies:
+//  1. poetry.lock, exported with the hashes of the locked packages;
+//  2. the static PEP 621 `project.dependencies`;
+//  3. the PEP 517 build system, by installing the project itself, which pulls in its dependencies.
+//
+// The generated file is keyed by the files it was generated from, see writeRequirements, and in the third
+// case also by the sources of the project, which is installed with its dependencies.
+func ExportProjectRequirements(ctx *gcp.Context, dir string) (string, error) {
+	if !ctx.FileExists(PyprojectTOML) {
+		return "", nil
+	}
+	var pyproject pyprojectTOML
+	if _, err := toml.Decode(string(ctx.ReadFile(PyprojectTOML)), &pyproject); err != nil {
+		return "", gcp.UserErrorf("parsing %s: %v", PyprojectTOML, err)
+	}
+
+	var reqs string
+	var sources []string
+	switch {
+	case ctx.FileExists(PoetryLock):
+		var lock poetryLockTOML
+		if _, err := toml.Decode(string(ctx.ReadFile(PoetryLock)), &lock); err != nil {
+			return "", gcp.UserErrorf("parsing %s: %v", PoetryLock, err)
+		}
+		var hashed bool
+		reqs, hashed = poetryRequirements(&pyproject, &lock, ctx.ApplicationRoot())
+		if !hashed {
+			ctx.Warnf("Some packages in %s have no file hashes, installing all packages without hash checking.", PoetryLock)
+		}
+		sources = []string{PyprojectTOML, PoetryLock}
+	case pyproject.Project != nil && !contains(pyproject.Project.Dynamic, "dependencies"):
+		reqs = strings.Join(pyproject.Project.Dependencies, "\n") + "\n"
+		sources = []string{PyprojectTOML}
+	case pyproject.BuildSystem != nil:
+		// pip builds the project with its PEP 517 build backend and installs its dependencies. The project
+		// itself is installed into the cached layer, so the requirements are keyed by all of its sources.
+		hash, err := projectHash(ctx.ApplicationRoot())
+		if err != nil {
+			return "", gcp.InternalErrorf("hashing project sources: %v", err)
+		}
+		reqs = fmt.Sprintf("# Project sources (sha256:%s).\n.\n", hash)
+		sources = []string{PyprojectTOML}
+	default:
+		ctx.Debugf("%s declares neither dependencies nor a build system, skipping.", PyprojectTOML)
+		return "", nil
+	}
+
+	return writeRequirements(ctx, dir, reqs, sources...), nil
+}
+
+// writeRequirements writes the given requirements to a requirements file in the given directory and
+// returns its path. The file starts with the hash of the source files the requirements were generated
+// from, so that the dependency cache of InstallRequirements is keyed by them.
+func writeRequirements(ctx *gcp.Context, dir, reqs string, sources ...string) string {
+	h := sha256.New()
+	for _, f := range sources {
+		h.Write(ctx.ReadFile(f))
+	}
+	ctx.Logf("Installing dependencies from %s.", strings.Join(sources, " and "))
+	path := filepath.Join(dir, exportedRequirements)
+	header := fmt.Sprintf("# Generated from %s (sha256:%s).\n", strings.Join(sources, ", "), hex.EncodeToString(h.Sum(nil)))
+	ctx.WriteFile(path, []byte(header+reqs), 0644)
+	return path
+}
+
+// projectHash returns the hash of the paths and contents of the regular files of the project in the given
+// directory. Hidden files and directories, such as .git, and Python bytecode caches are left out.
+func projectHash(dir string) (string, error) {
+	h := sha256.New()
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if path != dir && (strings.HasPrefix(info.Name(), ".") || info.Name() == "__pycache__") {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		contents, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(h, "%s\x00%d\x00", rel, len(contents))
+		h.Write(contents)
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// poetryRequirements returns the requirements for the locked packages that the main dependencies of
+// the project require, including those of the extras they request, sorted by name. Development and
+// unrequested optional dependencies are left out.
+// Requirements include the hashes of the package files unless any package has none, e.g. because it is
+// installed from a git repository, in which case the second return value is false.
+func poetryRequirements(pyproject *pyprojectTOML, lock *poetryLockTOML, root string) (string, bool) {
+	packages := map[string]poetryPackage{}
+	for _, p := range lock.Package {
+		packages[normalizeName(p.Name)] = p
+	}
+
+	// Walk the dependency graph from the main dependencies of the project. The extras that a dependency
+	// requests pull in the optional dependencies that the locked package lists for them.
+	var queue []requirement
+	for name, spec := range pyproject.Tool.Poetry.Dependencies {
+		if !optional(spec) {
+			queue = append(queue, requirement{name, specExtras(spec)})
+		}
+	}
+	if pyproject.Project != nil {
+		for _, req := range pyproject.Project.Dependencies {
+			if r, ok := parseRequirement(req); ok {
+				queue = append(queue, r)
+			}
+		}
+	}
+	required := map[string]bool{}
+	requested := map[string]bool{}
+	for len(queue) > 0 {
+		r := queue[0]
+		queue = queue[1:]
+		name := normalizeName(r.name)
+		p, ok := packages[name]
+		if !ok {
+			// Entries such as `python` are constraints rather than packages.
+			continue
+		}
+		if !required[name] {
+			required[name] = true
+			for dep, spec := range p.Dependencies {
+				if !optional(spec) {
+					queue = append(queue, requirement{dep, specExtras(spec)})
+				}
+			}
+		}
+		for _, extra := range r.extras {
+			key := name + "[" + normalizeName(extra) + "]"
+			if requested[key] {
+				continue
+			}
+			requested[key] = true
+			for e, reqs := range p.Extras {
+				if normalizeName(e) != normalizeName(extra) {
+					continue
+				}
+				for _, req := range reqs {
+					if dep, ok := parseRequirement(req); ok {
+						queue = append(queue, dep)
+					}
+				}
+			}
+		}
+	}
+
+	names := make([]string, 0, len(required))
+	hashed := true
+	for name := range required {
+		names = append(names, name)
+		if len(packageHashes(lock, packages[name])) == 0 {
+			hashed = false
+		}
+	}
+	sort.Strings(names)
+
+	var sb strings.Builder
+	for _, name := range names {
+		p := packages[name]
+		req := fmt.Sprintf("%s==%s", p.Name, p.Version)
+		if p.Source != nil {
+			switch p.Source.Type {
+			case "git":
+				req = fmt.Sprintf("%s @ git+%s@%s", p.Name, p.Source.URL, p.Source.ResolvedReference)
+			case "directory", "file":
+				path := p.Source.URL
+				if !filepath.IsAbs(path) {
+					path = filepath.Join(root, path)
+				}
+				req = fmt.Sprintf("%s @ file://%s", p.Name, path)
+			case "url":
+				req = fmt.Sprintf("%s @ %s", p.Name, p.Source.URL)
+			}
+		}
+		if markers, ok := p.Markers.(string); ok && markers != "" {
+			req += " ; " + markers
+		}
+		if hashed {
+			for _, hash := range packageHashes(lock, p) {
+				req += " \\\n    --hash=" + hash
+			}
+		}
+		sb.WriteString(req + "\n")
+	}
+	return sb.String(), hashed
+}
+
+// packageHashes returns the sorted hashes of the files of the given locked package.
+func packageHashes(lock *poetryLockTOML, p poetryPackage) []string {
+	files := p.Files
+	if len(files) == 0 {
+		files = lock.Metadata.Files[p.Name]
+	}
+	var hashes []string
+	for _, f := range files {
+		if f.Hash != "" {
+			hashes = append(hashes, f.Hash)
+		}
+	}
+	sort.Strings(hashes)
+	return hashes
+}
+
+// requirement is a project and the extras requested of it.
+type requirement struct {
+	name   string
+	extras []string
+}
+
+// parseRequirement returns the project name and extras of a PEP 508 requirement, or of an entry of the
+// extras of a locked package, e.g. `httptools (>=0.5.0)`.
+func parseRequirement(req string) (requirement, bool) {
+	m := nameRegexp.FindStringSubmatch(req)
+	if m == nil {
+		return requirement{}, false
+	}
+	r := requirement{name: m[1]}
+	if m := extrasRegexp.FindStringSubmatch(req); m != nil {
+		for _, extra := range strings.Split(m[1], ",") {
+			if extra = strings.TrimSpace(extra); extra != "" {
+				r.extras = append(r.extras, extra)
+			}
+		}
+	}
+	return r, true
+}
+
+// specExtras returns the extras requested by the given Poetry dependency specification, e.g.
+// `standard` in `{version = "*", extras = ["standard"]}`.
+func specExtras(spec interface{}) []string {
+	table, ok := spec.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	list, _ := table["extras"].([]interface{})
+	var extras []string
+	for _, e := range list {
+		if extra, ok := e.(string); ok {
+			extras = append(extras, extra)
+		}
+	}
+	return extras
+}
+
+// optional returns true if the given Poetry dependency specification is an optional dependency,
+// which is only installed when an extra that requires it is requested.
+func optional(spec interface{}) bool {
+	if table, ok := spec.(map[string]interface{}); ok {
+		if opt, ok := table["optional"].(bool); ok {
+			return opt
+		}
+	}
+	return false
+}
+
+// normalizeName returns the PEP 503 normalized form of the given project name.
+func normalizeName(name string) string {
+	return strings.ToLower(separatorRegexp.ReplaceAllString(name, "-"))
+}
+
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
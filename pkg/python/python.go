@@ -61,10 +61,19 @@ func Version(ctx *gcp.Context) string {
 	return strings.TrimSpace(result.Stdout)
 }
 
-// InstallRequirements installs dependencies from the given requirements files in a virtual env.
+// InstallRequirements installs dependencies from the given requirements files in the given layer.
 // It will install the files in order in which they are specified, so that dependencies specified
 // in later requirements files can override later ones.
 //
+// Dependencies are installed in a virtual env isolated from the system site-packages when
+// FUNC_PYTHON_ISOLATED_VENV is enabled, and in the user site-packages or, for Python 3.7 and 3.8, a
+// virtual env that inherits them otherwise.
+//
+// Requirements files other than those listed in RequirementsFilesEnv, which other buildpacks provide,
+// are the application's requirements. When FUNC_PIP_REQUIRE_HASHES is enabled, they must pin every
+// requirement to an exact version with hashes and are installed with `--require-hashes`; when
+// FUNC_PIP_ONLY_BINARY is set, they are installed with `--only-binary`.
+//
 // This function is responsible for installing requirements files for all buildpacks that require
 // it. The buildpacks used to install requirements into separate layers and add the layer path to
 // PYTHONPATH. However, this caused issues with some packages as it would allow users to
@@ -78,20 +87,29 @@ func InstallRequirements(ctx *gcp.Context, l *libcnb.Layer, reqs ...string) erro
 		return nil
 	}
 
-	// Check if we can use the cached-layer as is without reinstalling dependencies.
-	cached, err := checkCache(ctx, l, cache.WithFiles(reqs...))
+	pipOpts, requireHashes, err := pipEnforcementOptions()
 	if err != nil {
-		return fmt.Errorf("checking cache: %w", err)
+		return err
 	}
-	if cached {
-		ctx.CacheHit(l.Name)
-		return nil
+	isolated, err := isolatedVirtualEnv()
+	if err != nil {
+		return err
+	}
+	provided := map[string]bool{}
+	for _, req := range filepath.SplitList(os.Getenv(RequirementsFilesEnv)) {
+		provided[req] = true
+	}
+	var appReqs []string
+	for _, req := range reqs {
+		if !provided[req] {
+			appReqs = append(appReqs, req)
+		}
+	}
+	if requireHashes {
+		if err := checkPinnedRequirements(appReqs...); err != nil {
+			return err
+		}
 	}
-	ctx.CacheMiss(l.Name)
-
-	// The cache layer is used as PIP_CACHE_DIR to keep the cache directory across builds in case
-	// we do not get a full cache hit.
-	cl := ctx.Layer(cacheName, gcp.CacheLayer)
 
 	// History of the logic below:
 	//
@@ -100,20 +118,17 @@ func InstallRequirements(ctx *gcp.Context, l *libcnb.Layer, reqs ...string) erro
 	// We also cannot _not_ use --upgrade, see the requirements_bin_conflict acceptance test.
 	//
 	// Instead, we use Python per-user site-packages (https://www.python.org/dev/peps/pep-0370/)
-	// where we can and virtualenv where we cannot.
+	// where we can and virtualenv where we cannot, or where an isolated virtualenv is requested.
 	//
 	// Each requirements file is installed separately to allow the requirements.txt files
 	// to specify conflicting dependencies (e.g. functions-framework pins package A at 1.2.0 but
 	// the user's requirements.txt file pins A at 1.4.0. The user should be able to override
 	// the functions-framework-pinned package).
-
-	// HACK: For backwards compatibility with Python 3.7 and 3.8 on App Engine and Cloud Functions.
-	virtualEnv := requiresVirtualEnv()
+	//
+	// The environment is set up before checking the cache so that the commands below and those of
+	// the calling buildpack use the installed dependencies on a cache hit as well.
+	virtualEnv := isolated || requiresVirtualEnv()
 	if virtualEnv {
-		// --without-pip and --system-site-packages allow us to use `pip` and other packages from the
-		// build image and avoid reinstalling them, saving about 10MB.
-		// TODO(b/140775593): Use virtualenv pip after FTL is no longer used and remove from build image.
-		ctx.Exec([]string{"python3", "-m", "venv", "--without-pip", "--system-site-packages", l.Path})
 		// The VIRTUAL_ENV variable is usually set by the virtual environment's activate script.
 		l.SharedEnvironment.Override("VIRTUAL_ENV", l.Path)
 		// Use the virtual environment python3 for all subsequent commands in this buildpack, for
@@ -125,6 +140,43 @@ func InstallRequirements(ctx *gcp.Context, l *libcnb.Layer, reqs ...string) erro
 		ctx.Setenv("PYTHONUSERBASE", l.Path)
 	}
 
+	// Check if we can use the cached-layer as is without reinstalling dependencies.
+	cacheOpts := []cache.Option{cache.WithFiles(reqs...), cache.WithStrings(pipOpts...)}
+	if isolated {
+		cacheOpts = append(cacheOpts, cache.WithStrings(env.PythonIsolatedVenv))
+	}
+	cached, err := checkCache(ctx, l, cacheOpts...)
+	if err != nil {
+		return fmt.Errorf("checking cache: %w", err)
+	}
+	if cached {
+		ctx.CacheHit(l.Name)
+		return nil
+	}
+	ctx.CacheMiss(l.Name)
+
+	// The cache layer is used as PIP_CACHE_DIR to keep the cache directory across builds in case
+	// we do not get a full cache hit.
+	cl := ctx.Layer(cacheName, gcp.CacheLayer)
+
+	if isolated {
+		// The virtual environment includes its own pip and none of the packages of the build image,
+		// so that dependencies resolve the same way for every Python version.
+		ctx.Logf("Creating an isolated virtual environment.")
+		ctx.Exec([]string{"python3", "-m", "venv", l.Path}, gcp.WithUserAttribution)
+	} else if virtualEnv {
+		// HACK: For backwards compatibility with Python 3.7 and 3.8 on App Engine and Cloud Functions.
+		// --without-pip and --system-site-packages allow us to use `pip` and other packages from the
+		// build image and avoid reinstalling them, saving about 10MB.
+		// TODO(b/140775593): Use virtualenv pip after FTL is no longer used and remove from build image.
+		ctx.Exec([]string{"python3", "-m", "venv", "--without-pip", "--system-site-packages", l.Path})
+	}
+
+	// Wheels built from source distributions are cached across builds, so that expensive builds
+	// happen once rather than on every miss or expiry of the dependency cache.
+	wheels := newWheelCache(ctx)
+	pipEnv := gcp.WithEnv("PIP_CACHE_DIR="+cl.Path, "PIP_DISABLE_PIP_VERSION_CHECK=1")
+
 	for _, req := range reqs {
 		cmd := []string{
 			"python3", "-m", "pip", "install",
@@ -139,10 +191,24 @@ func InstallRequirements(ctx *gcp.Context, l *libcnb.Layer, reqs ...string) erro
 		if !virtualEnv {
 			cmd = append(cmd, "--user") // Install into user site-packages directory.
 		}
-		ctx.Exec(cmd,
-			gcp.WithEnv("PIP_CACHE_DIR="+cl.Path, "PIP_DISABLE_PIP_VERSION_CHECK=1"),
-			gcp.WithUserAttribution)
+		var opts []string
+		if !provided[req] {
+			opts = pipOpts
+		}
+		// Locally built wheels do not match the hashes of the source distributions they are built from, so
+		// requirements files with hashes, e.g. exported from poetry.lock or Pipfile.lock, bypass the wheel cache.
+		hashed, err := hashedRequirements(req, map[string]bool{})
+		if err != nil {
+			return gcp.UserErrorf("checking requirements in %s: %v", req, err)
+		}
+		if !hashed && (provided[req] || !requireHashes) {
+			wheels.build(ctx, req, opts, pipEnv)
+			cmd = append(cmd, wheels.findLinks()...)
+		}
+		cmd = append(cmd, opts...)
+		ctx.Exec(cmd, pipEnv, gcp.WithUserAttribution)
 	}
+	wheels.prune(ctx)
 
 	// Generate deterministic hash-based pycs (https://www.python.org/dev/peps/pep-0552/).
 	// Use the unchecked version to skip hash validation at run time (for faster startup).
@@ -217,6 +283,32 @@ func cacheExpired(ctx *gcp.Context, l *libcnb.Layer) bool {
 	return !t.After(time.Now())
 }
 
+// isolatedVirtualEnv returns true if dependencies are installed in a virtual env isolated from the
+// system site-packages, see env.PythonIsolatedVenv.
+func isolatedVirtualEnv() (bool, error) {
+	isolated, err := env.IsPythonIsolatedVenv()
+	if err != nil {
+		return false, gcp.UserErrorf("failed to parse %s: %v", env.PythonIsolatedVenv, err)
+	}
+	return isolated, nil
+}
+
+// usesVirtualEnv returns true if dependencies are installed in a virtual env rather than in the
+// user site-packages.
+func usesVirtualEnv() bool {
+	isolated, err := isolatedVirtualEnv()
+	return (err == nil && isolated) || requiresVirtualEnv()
+}
+
+// layerPython returns the Python interpreter and the exec options that import the dependencies
+// installed in the given layer, before its environment applies to the build.
+func layerPython(l *libcnb.Layer) (string, []gcp.ExecOption) {
+	if usesVirtualEnv() {
+		return filepath.Join(l.Path, "bin", "python3"), nil
+	}
+	return "python3", []gcp.ExecOption{gcp.WithEnv("PYTHONUSERBASE=" + l.Path)}
+}
+
 // requiresVirtualEnv returns true for runtimes that require a virtual environment to be created before pip install.
 // We cannot use Python per-user site-packages (https://www.python.org/dev/peps/pep-0370/),
 // because Python 3.7 and 3.8 on App Engine and Cloud Functions have a virtualenv set up
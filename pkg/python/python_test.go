@@ -0,0 +1,705 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package python
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/BurntSushi/toml"
+	"github.com/GoogleCloudPlatform/buildpacks/pkg/env"
+	gcp "github.com/GoogleCloudPlatform/buildpacks/pkg/gcpbuildpack"
+	"github.com/buildpacks/libcnb"
+)
+
+func TestPoetryRequirements(t *testing.T) {
+	testCases := []struct {
+		name       string
+		pyproject  string
+		lock       string
+		want       string
+		wantHashed bool
+	}{
+		{
+			name: "lock format 1.1 skips dev and optional dependencies",
+			pyproject: `
+[tool.poetry.dependencies]
+python = "^3.8"
+Flask = "^2.0"
+redis = {version = "^4.0", optional = true}
+
+[tool.poetry.dev-dependencies]
+pytest = "^6.2"
+`,
+			lock: `
+[[package]]
+name = "flask"
+version = "2.0.2"
+category = "main"
+
+[package.dependencies]
+click = ">=7.1.2"
+Werkzeug = ">=2.0"
+python-dotenv = {version = "*", optional = true}
+
+[[package]]
+name = "click"
+version = "8.0.3"
+category = "main"
+markers = "python_version >= \"3.6\""
+
+[[package]]
+name = "werkzeug"
+version = "2.0.2"
+category = "main"
+
+[[package]]
+name = "python-dotenv"
+version = "0.19.2"
+category = "main"
+optional = true
+
+[[package]]
+name = "redis"
+version = "4.0.2"
+category = "main"
+optional = true
+
+[[package]]
+name = "pytest"
+version = "6.2.5"
+category = "dev"
+
+[metadata.files]
+click = [{file = "click-8.0.3.tar.gz", hash = "sha256:bbb"}, {file = "click-8.0.3-py3-none-any.whl", hash = "sha256:aaa"}]
+flask = [{file = "Flask-2.0.2.tar.gz", hash = "sha256:ccc"}]
+werkzeug = [{file = "Werkzeug-2.0.2.tar.gz", hash = "sha256:ddd"}]
+`,
+			want: `click==8.0.3 ; python_version >= "3.6" \
+    --hash=sha256:aaa \
+    --hash=sha256:bbb
+flask==2.0.2 \
+    --hash=sha256:ccc
+werkzeug==2.0.2 \
+    --hash=sha256:ddd
+`,
+			wantHashed: true,
+		},
+		{
+			name: "lock format 2.0 with PEP 621 dependencies",
+			pyproject: `
+[project]
+dependencies = ["requests[socks]>=2.26"]
+`,
+			lock: `
+[[package]]
+name = "requests"
+version = "2.26.0"
+files = [{file = "requests-2.26.0.tar.gz", hash = "sha256:eee"}]
+
+[package.dependencies]
+idna = ">=2.5"
+
+[[package]]
+name = "idna"
+version = "3.3"
+files = [{file = "idna-3.3.tar.gz", hash = "sha256:fff"}]
+`,
+			want: `idna==3.3 \
+    --hash=sha256:fff
+requests==2.26.0 \
+    --hash=sha256:eee
+`,
+			wantHashed: true,
+		},
+		{
+			name: "extras pull in optional dependencies",
+			pyproject: `
+[tool.poetry.dependencies]
+uvicorn = {version = "*", extras = ["standard"]}
+`,
+			lock: `
+[[package]]
+name = "uvicorn"
+version = "0.20.0"
+files = [{file = "uvicorn-0.20.0.tar.gz", hash = "sha256:hhh"}]
+
+[package.dependencies]
+h11 = ">=0.8"
+httptools = {version = ">=0.5.0", optional = true, markers = "extra == \"standard\""}
+websockets = {version = ">=10.4", optional = true, markers = "extra == \"standard\""}
+
+[package.extras]
+standard = ["httptools (>=0.5.0)", "websockets (>=10.4)"]
+
+[[package]]
+name = "h11"
+version = "0.14.0"
+files = [{file = "h11-0.14.0.tar.gz", hash = "sha256:iii"}]
+
+[[package]]
+name = "httptools"
+version = "0.5.0"
+optional = true
+files = [{file = "httptools-0.5.0.tar.gz", hash = "sha256:jjj"}]
+
+[[package]]
+name = "websockets"
+version = "10.4"
+optional = true
+files = [{file = "websockets-10.4.tar.gz", hash = "sha256:kkk"}]
+`,
+			want: `h11==0.14.0 \
+    --hash=sha256:iii
+httptools==0.5.0 \
+    --hash=sha256:jjj
+uvicorn==0.20.0 \
+    --hash=sha256:hhh
+websockets==10.4 \
+    --hash=sha256:kkk
+`,
+			wantHashed: true,
+		},
+		{
+			name: "git dependency disables hashes",
+			pyproject: `
+[tool.poetry.dependencies]
+mylib = {git = "https://example.com/mylib.git"}
+six = "*"
+`,
+			lock: `
+[[package]]
+name = "mylib"
+version = "0.1.0"
+
+[package.source]
+type = "git"
+url = "https://example.com/mylib.git"
+resolved_reference = "abc123"
+
+[[package]]
+name = "six"
+version = "1.16.0"
+files = [{file = "six-1.16.0.tar.gz", hash = "sha256:ggg"}]
+`,
+			want: `mylib @ git+https://example.com/mylib.git@abc123
+six==1.16.0
+`,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var pyproject pyprojectTOML
+			if _, err := toml.Decode(tc.pyproject, &pyproject); err != nil {
+				t.Fatalf("decoding pyproject.toml: %v", err)
+			}
+			var lock poetryLockTOML
+			if _, err := toml.Decode(tc.lock, &lock); err != nil {
+				t.Fatalf("decoding poetry.lock: %v", err)
+			}
+			got, gotHashed := poetryRequirements(&pyproject, &lock, "/workspace")
+			if got != tc.want || gotHashed != tc.wantHashed {
+				t.Errorf("poetryRequirements() = (%q, %t), want (%q, %t)", got, gotHashed, tc.want, tc.wantHashed)
+			}
+		})
+	}
+}
+
+func TestNormalizeName(t *testing.T) {
+	for name, want := range map[string]string{
+		"Flask":            "flask",
+		"python_dotenv":    "python-dotenv",
+		"zope.interface":   "zope-interface",
+		"Some__Weird-.Pkg": "some-weird-pkg",
+	} {
+		if got := normalizeName(name); got != want {
+			t.Errorf("normalizeName(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestProjectHash(t *testing.T) {
+	base := map[string]string{
+		"pyproject.toml":    "[build-system]\nrequires = [\"setuptools\"]\n",
+		"mypkg/__init__.py": "",
+		"mypkg/main.py":     "app = None\n",
+	}
+	testCases := []struct {
+		name     string
+		files    map[string]string
+		remove   []string
+		wantSame bool
+	}{
+		{
+			name:  "changed source",
+			files: map[string]string{"mypkg/main.py": "app = 1\n"},
+		},
+		{
+			name:  "added source",
+			files: map[string]string{"mypkg/util.py": ""},
+		},
+		{
+			name:   "renamed source",
+			files:  map[string]string{"mypkg/app.py": "app = None\n"},
+			remove: []string{"mypkg/main.py"},
+		},
+		{
+			name:     "git directory",
+			files:    map[string]string{".git/HEAD": "ref: refs/heads/main\n"},
+			wantSame: true,
+		},
+		{
+			name:     "bytecode cache",
+			files:    map[string]string{"mypkg/__pycache__/main.cpython-39.pyc": "bytecode"},
+			wantSame: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			d, err := ioutil.TempDir("", "test-project-hash-")
+			if err != nil {
+				t.Fatalf("Failed to create temp dir: %v", err)
+			}
+			defer os.RemoveAll(d)
+			write := func(files map[string]string) {
+				for f, c := range files {
+					if err := os.MkdirAll(filepath.Dir(filepath.Join(d, f)), 0755); err != nil {
+						t.Fatalf("Failed to create dir for %s: %v", f, err)
+					}
+					if err := ioutil.WriteFile(filepath.Join(d, f), []byte(c), 0644); err != nil {
+						t.Fatalf("Failed to write %s: %v", f, err)
+					}
+				}
+			}
+
+			write(base)
+			before, err := projectHash(d)
+			if err != nil {
+				t.Fatalf("projectHash() got error: %v", err)
+			}
+			for _, f := range tc.remove {
+				if err := os.Remove(filepath.Join(d, f)); err != nil {
+					t.Fatalf("Failed to remove %s: %v", f, err)
+				}
+			}
+			write(tc.files)
+			after, err := projectHash(d)
+			if err != nil {
+				t.Fatalf("projectHash() got error: %v", err)
+			}
+			if got := before == after; got != tc.wantSame {
+				t.Errorf("projectHash() unchanged = %t, want %t", got, tc.wantSame)
+			}
+		})
+	}
+}
+
+func TestPipfileRequirements(t *testing.T) {
+	testCases := []struct {
+		name       string
+		lock       string
+		want       string
+		wantHashed bool
+	}{
+		{
+			name: "pinned with hashes",
+			lock: `{
+  "_meta": {"hash": {"sha256": "123"}},
+  "default": {
+    "requests": {"version": "==2.26.0", "extras": ["socks"], "hashes": ["sha256:bbb", "sha256:aaa"]},
+    "idna": {"version": "==3.3", "markers": "python_version >= '3'", "hashes": ["sha256:ccc"]}
+  },
+  "develop": {
+    "pytest": {"version": "==6.2.5", "hashes": ["sha256:ddd"]}
+  }
+}`,
+			want: `idna==3.3 ; python_version >= '3' \
+    --hash=sha256:ccc
+requests[socks]==2.26.0 \
+    --hash=sha256:aaa \
+    --hash=sha256:bbb
+`,
+			wantHashed: true,
+		},
+		{
+			name: "git and path packages disable hashes",
+			lock: `{
+  "default": {
+    "mylib": {"git": "https://example.com/mylib.git", "ref": "abc123"},
+    "local": {"path": "./local", "editable": true},
+    "six": {"version": "==1.16.0", "hashes": ["sha256:eee"]}
+  }
+}`,
+			want: `--editable /workspace/local
+mylib @ git+https://example.com/mylib.git@abc123
+six==1.16.0
+`,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var lock pipfileLockJSON
+			if err := json.Unmarshal([]byte(tc.lock), &lock); err != nil {
+				t.Fatalf("decoding Pipfile.lock: %v", err)
+			}
+			got, gotHashed := pipfileRequirements(&lock, "/workspace")
+			if got != tc.want || gotHashed != tc.wantHashed {
+				t.Errorf("pipfileRequirements() = (%q, %t), want (%q, %t)", got, gotHashed, tc.want, tc.wantHashed)
+			}
+		})
+	}
+}
+
+func TestUnpinnedRequirements(t *testing.T) {
+	d, err := ioutil.TempDir("", "test-unpinned-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(d)
+
+	files := map[string]string{
+		"requirements.txt": `# Pinned with pip-compile.
+--index-url https://pypi.org/simple
+-r base.txt
+flask==2.0.2 \
+    --hash=sha256:aaa \
+    --hash=sha256:bbb    # via -r requirements.in
+requests>=2.26.0 --hash=sha256:ccc
+idna==3.3 ; python_version >= "3"
+mylib @ https://example.com/mylib-1.0.tar.gz --hash=sha256:ddd
+-e ./local
+click
+attrs ; python_version == "3.8" --hash=sha256:ggg
+`,
+		"base.txt": `six===1.16.0 --hash=sha256:eee
+urllib3==1.*  --hash=sha256:fff
+`,
+	}
+	for f, c := range files {
+		if err := ioutil.WriteFile(filepath.Join(d, f), []byte(c), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", f, err)
+		}
+	}
+
+	got, err := unpinnedRequirements(filepath.Join(d, "requirements.txt"), map[string]bool{})
+	if err != nil {
+		t.Fatalf("unpinnedRequirements() got error: %v", err)
+	}
+	want := []string{
+		"base.txt:2: urllib3==1.* (no exact == version pin)",
+		"requirements.txt:7: requests>=2.26.0 (no exact == version pin)",
+		`requirements.txt:8: idna==3.3 ; python_version >= "3" (no --hash)`,
+		"requirements.txt:10: -e ./local (editable requirements cannot be hash-checked)",
+		"requirements.txt:11: click (no exact == version pin, no --hash)",
+		`requirements.txt:12: attrs ; python_version == "3.8" (no exact == version pin)`,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("unpinnedRequirements() = %q, want %q", got, want)
+	}
+}
+
+func TestTargetProblem(t *testing.T) {
+	one, two, varargs := 1, 2, -1
+	testCases := []struct {
+		name          string
+		report        probeReport
+		signatureType string
+		want          string
+	}{
+		{
+			name:          "http function",
+			report:        probeReport{Exists: true, Callable: true, MinArgs: &one, MaxArgs: &one},
+			signatureType: "http",
+		},
+		{
+			name:          "event function with optional context",
+			report:        probeReport{Exists: true, Callable: true, MinArgs: &one, MaxArgs: &two},
+			signatureType: "event",
+		},
+		{
+			name:          "varargs",
+			report:        probeReport{Exists: true, Callable: true, MinArgs: &one, MaxArgs: &varargs},
+			signatureType: "event",
+		},
+		{
+			name:          "unknown signature type",
+			report:        probeReport{Exists: true, Callable: true, MinArgs: &two, MaxArgs: &two},
+			signatureType: "openfunction",
+		},
+		{
+			name:          "missing",
+			report:        probeReport{},
+			signatureType: "http",
+			want:          `function "target" is not defined`,
+		},
+		{
+			name:          "not callable",
+			report:        probeReport{Exists: true},
+			signatureType: "http",
+			want:          `"target" is not callable`,
+		},
+		{
+			name:          "too many required arguments",
+			report:        probeReport{Exists: true, Callable: true, MinArgs: &two, MaxArgs: &two},
+			signatureType: "http",
+			want:          `function "target" must accept 1 positional argument(s) for signature type "http", but accepts 2`,
+		},
+		{
+			name:          "too few arguments",
+			report:        probeReport{Exists: true, Callable: true, MinArgs: &one, MaxArgs: &one},
+			signatureType: "event",
+			want:          `function "target" must accept 2 positional argument(s) for signature type "event", but accepts 1`,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := targetProblem(&tc.report, "target", tc.signatureType); got != tc.want {
+				t.Errorf("targetProblem() = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestWheelCacheRecord(t *testing.T) {
+	output := `Collecting six==1.16.0
+  Using cached six-1.16.0-py2.py3-none-any.whl (11 kB)
+Processing /layers/wheels/grpcio-1.42.0-cp39-cp39-linux_x86_64.whl
+Building wheels for collected packages: pyyaml
+  Building wheel for pyyaml (setup.py): finished with status 'done'
+  Created wheel for pyyaml: filename=PyYAML-5.4.1-cp39-cp39-linux_x86_64.whl size=45655
+Saved /layers/wheels/six-1.16.0-py2.py3-none-any.whl
+File was already downloaded /layers/wheels/grpcio-1.42.0-cp39-cp39-linux_x86_64.whl
+Saved ./PyYAML-5.4.1-cp39-cp39-linux_x86_64.whl
+Successfully built pyyaml
+`
+	w := &wheelCache{
+		cached: map[string]bool{"grpcio-1.42.0-cp39-cp39-linux_x86_64.whl": true},
+		used:   map[string]bool{},
+	}
+	w.record(output)
+	// The downloaded six wheel is left to the pip cache.
+	want := map[string]bool{
+		"grpcio-1.42.0-cp39-cp39-linux_x86_64.whl": true,
+		"PyYAML-5.4.1-cp39-cp39-linux_x86_64.whl":  true,
+	}
+	if !w.reported || !reflect.DeepEqual(w.used, want) {
+		t.Errorf("record() used = %v (reported %t), want %v (reported true)", w.used, w.reported, want)
+	}
+}
+
+func TestHashedRequirements(t *testing.T) {
+	testCases := []struct {
+		name  string
+		files map[string]string
+		want  bool
+	}{
+		{
+			name:  "no hashes",
+			files: map[string]string{"requirements.txt": "flask==2.0.2\n# --hash=sha256:aaa\n"},
+		},
+		{
+			name:  "hash on a continuation line",
+			files: map[string]string{"requirements.txt": "flask==2.0.2 \\\n    --hash=sha256:aaa\n"},
+			want:  true,
+		},
+		{
+			name: "hash in an included file",
+			files: map[string]string{
+				"requirements.txt": "-r base.txt\nflask==2.0.2\n",
+				"base.txt":         "six==1.16.0 --hash=sha256:bbb\n",
+			},
+			want: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			d, err := ioutil.TempDir("", "test-hashed-requirements-")
+			if err != nil {
+				t.Fatalf("Failed to create temp dir: %v", err)
+			}
+			defer os.RemoveAll(d)
+			for f, c := range tc.files {
+				if err := ioutil.WriteFile(filepath.Join(d, f), []byte(c), 0644); err != nil {
+					t.Fatalf("Failed to write %s: %v", f, err)
+				}
+			}
+
+			got, err := hashedRequirements(filepath.Join(d, "requirements.txt"), map[string]bool{})
+			if err != nil {
+				t.Fatalf("hashedRequirements() got error: %v", err)
+			}
+			if got != tc.want {
+				t.Errorf("hashedRequirements() = %t, want %t", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestDetectApp(t *testing.T) {
+	testCases := []struct {
+		name  string
+		files map[string]string
+		want  *App
+	}{
+		{
+			name:  "flask app in main.py",
+			files: map[string]string{"main.py": "from flask import Flask\n\napp = Flask(__name__)\n"},
+			want:  &App{Ref: "main:app"},
+		},
+		{
+			name:  "annotated fastapi app in main.py",
+			files: map[string]string{"main.py": "from fastapi import FastAPI\n\napp: FastAPI = FastAPI()\n"},
+			want:  &App{Ref: "main:app", ASGI: true},
+		},
+		{
+			name:  "fastapi app in app/main.py",
+			files: map[string]string{"app/main.py": "import fastapi\napp = fastapi.FastAPI()\n"},
+			want:  &App{Ref: "app.main:app", ASGI: true},
+		},
+		{
+			name:  "comparison is not an app",
+			files: map[string]string{"main.py": "app == None\n"},
+		},
+		{
+			name:  "nested app is not an app",
+			files: map[string]string{"main.py": "def create():\n    app = Flask(__name__)\n"},
+		},
+		{
+			name: "django project from manage.py settings",
+			files: map[string]string{
+				"manage.py":          `os.environ.setdefault("DJANGO_SETTINGS_MODULE", "mysite.settings")`,
+				"mysite/wsgi.py":     "",
+				"mysite/settings.py": "",
+				"other/wsgi.py":      "",
+			},
+			want: &App{Ref: "mysite.wsgi:application"},
+		},
+		{
+			name: "django project with a single wsgi.py",
+			files: map[string]string{
+				"manage.py":    "",
+				"site/wsgi.py": "",
+			},
+			want: &App{Ref: "site.wsgi:application"},
+		},
+		{
+			name:  "wsgi.py without manage.py",
+			files: map[string]string{"site/wsgi.py": ""},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			d, err := ioutil.TempDir("", "test-detect-app-")
+			if err != nil {
+				t.Fatalf("Failed to create temp dir: %v", err)
+			}
+			defer os.RemoveAll(d)
+			for f, c := range tc.files {
+				if err := os.MkdirAll(filepath.Dir(filepath.Join(d, f)), 0755); err != nil {
+					t.Fatalf("Failed to create dir for %s: %v", f, err)
+				}
+				if err := ioutil.WriteFile(filepath.Join(d, f), []byte(c), 0644); err != nil {
+					t.Fatalf("Failed to write %s: %v", f, err)
+				}
+			}
+
+			got := DetectApp(gcp.NewContextForTests(libcnb.BuildpackInfo{}, d))
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("DetectApp() = %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestServerCommand(t *testing.T) {
+	testCases := []struct {
+		name   string
+		app    *App
+		server string
+		want   string
+	}{
+		{
+			name:   "wsgi with gunicorn",
+			app:    &App{Ref: "functions_framework:create_app()"},
+			server: Gunicorn,
+			want:   "exec gunicorn --bind :${PORT:-8080} --workers ${WEB_CONCURRENCY} --threads ${PYTHON_THREADS} --timeout 0 'functions_framework:create_app()'",
+		},
+		{
+			name:   "asgi with gunicorn",
+			app:    &App{Ref: "main:app", ASGI: true},
+			server: Gunicorn,
+			want:   "exec gunicorn --bind :${PORT:-8080} --workers ${WEB_CONCURRENCY} --worker-class uvicorn.workers.UvicornWorker --timeout 0 'main:app'",
+		},
+		{
+			name:   "asgi with uvicorn",
+			app:    &App{Ref: "main:app", ASGI: true},
+			server: Uvicorn,
+			want:   "exec uvicorn --host 0.0.0.0 --port ${PORT:-8080} --workers ${WEB_CONCURRENCY} 'main:app'",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := ServerCommand(tc.app, tc.server); got != tc.want {
+				t.Errorf("ServerCommand() = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestUsesVirtualEnv(t *testing.T) {
+	testCases := []struct {
+		name     string
+		runtime  string
+		isolated string
+		want     bool
+	}{
+		{
+			name:    "python39 user site-packages",
+			runtime: "python39",
+		},
+		{
+			name:    "python38 inherited virtual env",
+			runtime: "python38",
+			want:    true,
+		},
+		{
+			name:     "python39 isolated virtual env",
+			runtime:  "python39",
+			isolated: "true",
+			want:     true,
+		},
+		{
+			name:     "isolated virtual env disabled",
+			isolated: "false",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			defer os.Unsetenv(env.Runtime)
+			defer os.Unsetenv(env.PythonIsolatedVenv)
+			os.Setenv(env.Runtime, tc.runtime)
+			if tc.isolated != "" {
+				os.Setenv(env.PythonIsolatedVenv, tc.isolated)
+			}
+			if got := usesVirtualEnv(); got != tc.want {
+				t.Errorf("usesVirtualEnv() = %t, want %t", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,181 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package python
+
+import (
+	_ "embed" // for the launcher script
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	gcp "github.com/GoogleCloudPlatform/buildpacks/pkg/gcpbuildpack"
+	"github.com/blang/semver"
+	"github.com/buildpacks/libcnb"
+)
+
+const (
+	// Gunicorn is the production server for WSGI apps, and for ASGI apps with uvicorn workers.
+	Gunicorn = "gunicorn"
+	// Uvicorn is the production server for ASGI apps when gunicorn is not installed.
+	Uvicorn = "uvicorn"
+
+	launcherLayer = "launcher"
+	launcherFile  = "launch.sh"
+
+	// minFactoryGunicorn is the first gunicorn version that loads app factories, e.g.
+	// `functions_framework:create_app()`.
+	minFactoryGunicorn = "20.1.0"
+)
+
+// launcherScript sizes the web server to the CPU and memory limits of the container.
+//
+//go:embed launch.sh
+var launcherScript []byte
+
+var (
+	// appRegexp matches a top-level assignment of `app`, e.g. `app = Flask(__name__)`.
+	appRegexp = regexp.MustCompile(`(?m)^app\s*(:[^=\n]*)?=[^=]`)
+	// asgiRegexp matches an import of a framework that builds ASGI apps.
+	asgiRegexp = regexp.MustCompile(`(?m)^\s*(from|import)\s+(fastapi|starlette|quart|litestar|django\.core\.asgi)\b`)
+	// djangoSettingsRegexp matches the default settings module in manage.py, e.g.
+	// `os.environ.setdefault("DJANGO_SETTINGS_MODULE", "mysite.settings")`.
+	djangoSettingsRegexp = regexp.MustCompile(`DJANGO_SETTINGS_MODULE['"]\s*,\s*['"]([\w.]+)\.settings['"]`)
+)
+
+// App is a WSGI or ASGI application that can be served by a production web server.
+type App struct {
+	// Ref is the application in gunicorn's `module:variable` notation, e.g. `main:app`.
+	Ref string
+	// ASGI is true for asynchronous applications, which are served by uvicorn workers.
+	ASGI bool
+}
+
+// appSources are the files that conventionally define an `app` variable, with their module names.
+var appSources = []struct{ file, module string }{
+	{"main.py", "main"},
+	{filepath.Join("app", "main.py"), "app.main"},
+}
+
+// DetectApp returns the application defined in the application root, or nil if none is found. It
+// looks for an `app` variable in main.py or app/main.py, e.g. for Flask or FastAPI, and then for the
+// WSGI module of a Django project.
+func DetectApp(ctx *gcp.Context) *App {
+	root := ctx.ApplicationRoot()
+	for _, src := range appSources {
+		if !ctx.FileExists(root, src.file) {
+			continue
+		}
+		content := ctx.ReadFile(filepath.Join(root, src.file))
+		if appRegexp.Match(content) {
+			return &App{Ref: src.module + ":app", ASGI: asgiRegexp.Match(content)}
+		}
+	}
+	if module := djangoWSGIModule(ctx, root); module != "" {
+		return &App{Ref: module + ":application"}
+	}
+	return nil
+}
+
+// djangoWSGIModule returns the WSGI module of the Django project in the given directory, e.g.
+// `mysite.wsgi`, or an empty string if there is none. The project package is the one that holds the
+// default settings module in manage.py or, failing that, the only package with a wsgi.py file.
+func djangoWSGIModule(ctx *gcp.Context, root string) string {
+	if !ctx.FileExists(root, "manage.py") {
+		return ""
+	}
+	if m := djangoSettingsRegexp.FindSubmatch(ctx.ReadFile(filepath.Join(root, "manage.py"))); m != nil {
+		pkg := string(m[1])
+		if ctx.FileExists(append([]string{root}, append(strings.Split(pkg, "."), "wsgi.py")...)...) {
+			return pkg + ".wsgi"
+		}
+	}
+	candidates := ctx.Glob(filepath.Join(root, "*", "wsgi.py"))
+	if len(candidates) != 1 {
+		return ""
+	}
+	return filepath.Base(filepath.Dir(candidates[0])) + ".wsgi"
+}
+
+// InstalledServer returns the production web server that is installed for the app, or a user error
+// explaining which one to add to the requirements. It must be called after the dependencies have
+// been installed.
+func InstalledServer(ctx *gcp.Context, app *App) (string, error) {
+	gunicorn := installed(ctx, Gunicorn)
+	if !app.ASGI {
+		if !gunicorn {
+			return "", gcp.UserErrorf("found WSGI app %q, but %s is not installed; add %s to your requirements", app.Ref, Gunicorn, Gunicorn)
+		}
+		return Gunicorn, nil
+	}
+	if !installed(ctx, Uvicorn) {
+		return "", gcp.UserErrorf("found ASGI app %q, but %s is not installed; add %s to your requirements", app.Ref, Uvicorn, Uvicorn)
+	}
+	if gunicorn {
+		return Gunicorn, nil
+	}
+	return Uvicorn, nil
+}
+
+// installed returns true if the given module can be imported.
+func installed(ctx *gcp.Context, module string) bool {
+	_, err := ctx.ExecWithErr([]string{"python3", "-c", "import " + module})
+	return err == nil
+}
+
+// CheckFactoryServer returns a user error unless the gunicorn installed in the given layer loads app
+// factories, as the app of the functions framework requires. It must be called after the dependencies
+// have been installed.
+func CheckFactoryServer(ctx *gcp.Context, l *libcnb.Layer) error {
+	python, opts := layerPython(l)
+	result, gerr := ctx.ExecWithErr([]string{python, "-c", "import gunicorn; print(gunicorn.__version__)"}, opts...)
+	if gerr != nil {
+		return gcp.UserErrorf("%s is not installed; add %s>=%s to your requirements", Gunicorn, Gunicorn, minFactoryGunicorn)
+	}
+	version := strings.TrimSpace(result.Stdout)
+	v, err := semver.ParseTolerant(version)
+	if err != nil {
+		return gcp.InternalErrorf("parsing %s version %q: %v", Gunicorn, version, err)
+	}
+	if v.LT(semver.MustParse(minFactoryGunicorn)) {
+		return gcp.UserErrorf("found %s %s, but functions are served with %s>=%s, which loads the app of the functions framework; update %s in your requirements", Gunicorn, version, Gunicorn, minFactoryGunicorn, Gunicorn)
+	}
+	return nil
+}
+
+// ServerCommand returns the shell command that serves the app with the given server. The number of
+// workers and threads are read from WEB_CONCURRENCY and PYTHON_THREADS, which the launcher sets.
+func ServerCommand(app *App, server string) string {
+	if server == Uvicorn {
+		return fmt.Sprintf("exec uvicorn --host 0.0.0.0 --port ${PORT:-8080} --workers ${WEB_CONCURRENCY} '%s'", app.Ref)
+	}
+	cmd := "exec gunicorn --bind :${PORT:-8080} --workers ${WEB_CONCURRENCY}"
+	if app.ASGI {
+		cmd += " --worker-class uvicorn.workers.UvicornWorker"
+	} else {
+		cmd += " --threads ${PYTHON_THREADS}"
+	}
+	// Disable the worker timeout, request timeouts are handled by the platform.
+	return fmt.Sprintf("%s --timeout 0 '%s'", cmd, app.Ref)
+}
+
+// AddWebServerProcess sets the default web process to start the given server command through the
+// launcher, which sizes the server to the CPU and memory limits of the container.
+func AddWebServerProcess(ctx *gcp.Context, command string) {
+	l := ctx.Layer(launcherLayer, gcp.LaunchLayer)
+	launcher := filepath.Join(l.Path, launcherFile)
+	ctx.WriteFile(launcher, launcherScript, 0755)
+	ctx.AddDefaultWebProcess([]string{launcher, "/bin/sh", "-c", command}, true)
+}
@@ -0,0 +1,116 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package python
+
+import (
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	gcp "github.com/GoogleCloudPlatform/buildpacks/pkg/gcpbuildpack"
+	"github.com/buildpacks/libcnb"
+)
+
+const (
+	wheelsLayer = "wheels"
+	// pythonABIKey is the layer metadata key of the Python ABI that the cached wheels were built for.
+	pythonABIKey = "python_abi"
+)
+
+var (
+	// wheelRegexp matches the wheels that `pip wheel` reports as built, downloaded or reused.
+	wheelRegexp = regexp.MustCompile(`(?m)(?:Saved|File was already downloaded) (\S+\.whl)\s*$`)
+	// builtRegexp matches the wheels that `pip wheel` reports as built from source distributions.
+	builtRegexp = regexp.MustCompile(`(?m)Created wheel for \S+: filename=(\S+\.whl)`)
+)
+
+// wheelCache is a cache layer of the wheels built from packages published only as source distributions.
+// Wheels that pip downloads are already in the pip cache and are not kept. Wheel file names carry the
+// package name, version and compatibility tags; the layer is cleared when the Python ABI changes.
+type wheelCache struct {
+	layer *libcnb.Layer
+	// cached holds the file names of the wheels in the cache before this build, all of them built ones.
+	cached map[string]bool
+	// used holds the file names of the built wheels required by this build.
+	used map[string]bool
+	// reported is true if pip reported any wheel, built or not.
+	reported bool
+}
+
+// newWheelCache returns the wheel cache for the installed Python.
+func newWheelCache(ctx *gcp.Context) *wheelCache {
+	l := ctx.Layer(wheelsLayer, gcp.CacheLayer)
+	abi := pythonABI(ctx)
+	if meta := ctx.GetMetadata(l, pythonABIKey); meta != abi {
+		ctx.Debugf("Python ABI changed from %q to %q, clearing wheel cache.", meta, abi)
+		ctx.ClearLayer(l)
+	}
+	ctx.SetMetadata(l, pythonABIKey, abi)
+	cached := map[string]bool{}
+	for _, whl := range ctx.Glob(filepath.Join(l.Path, "*.whl")) {
+		cached[filepath.Base(whl)] = true
+	}
+	return &wheelCache{layer: l, cached: cached, used: map[string]bool{}}
+}
+
+// build builds or downloads wheels for the given requirements file and its dependencies into the cache.
+// Wheels already in the cache are reused, so source distributions are only built once.
+func (w *wheelCache) build(ctx *gcp.Context, req string, opts []string, execOpts ...gcp.ExecOption) {
+	cmd := append([]string{
+		"python3", "-m", "pip", "wheel",
+		"--requirement", req,
+		"--wheel-dir", w.layer.Path,
+		"--find-links", w.layer.Path,
+	}, opts...)
+	result := ctx.Exec(cmd, append([]gcp.ExecOption{gcp.WithUserAttribution}, execOpts...)...)
+	w.record(result.Stdout)
+}
+
+// record marks the wheels in the given `pip wheel` output that were built, or reused from the cache, as used.
+func (w *wheelCache) record(output string) {
+	for _, m := range builtRegexp.FindAllStringSubmatch(output, -1) {
+		w.used[filepath.Base(m[1])] = true
+	}
+	for _, m := range wheelRegexp.FindAllStringSubmatch(output, -1) {
+		w.reported = true
+		if name := filepath.Base(m[1]); w.cached[name] {
+			w.used[name] = true
+		}
+	}
+}
+
+// findLinks returns the pip options that install from the wheels in the cache.
+func (w *wheelCache) findLinks() []string {
+	return []string{"--find-links", w.layer.Path}
+}
+
+// prune removes the downloaded wheels and the built wheels that this build did not require, e.g. older
+// versions of upgraded packages. Nothing is removed if pip reported no wheels, in case it changed its output.
+func (w *wheelCache) prune(ctx *gcp.Context) {
+	if !w.reported {
+		return
+	}
+	for _, whl := range ctx.Glob(filepath.Join(w.layer.Path, "*.whl")) {
+		if !w.used[filepath.Base(whl)] {
+			ctx.RemoveAll(whl)
+		}
+	}
+}
+
+// pythonABI returns an identifier of the ABI of the installed Python, e.g. `cpython-39-linux-x86_64`.
+func pythonABI(ctx *gcp.Context) string {
+	result := ctx.Exec([]string{"python3", "-c", "import sys, sysconfig; print(sys.implementation.cache_tag + '-' + sysconfig.get_platform())"})
+	return strings.TrimSpace(result.Stdout)
+}